@@ -1,17 +1,153 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/access"
+	"punkplod23/go-agent-ollama-slm/pkg/alerts"
 	"punkplod23/go-agent-ollama-slm/pkg/api"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"punkplod23/go-agent-ollama-slm/pkg/caz"
+	"punkplod23/go-agent-ollama-slm/pkg/clonecheck"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/notify"
+	"punkplod23/go-agent-ollama-slm/pkg/parking"
+	"punkplod23/go-agent-ollama-slm/pkg/ratelimit"
+	"punkplod23/go-agent-ollama-slm/pkg/safefetch"
+	"punkplod23/go-agent-ollama-slm/pkg/sightings"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"punkplod23/go-agent-ollama-slm/pkg/watchlist"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds how long shutdown waits for requests and webhooks.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 
-	cfg, err := config.LoadConfigFromEnv()
+	opts, err := config.ParseFlags(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to parse flags: %v", err)
+	}
+	if opts.PrintConfig {
+		if err := config.Print(os.Stdout, opts); err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(opts)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	api.StartServer(cfg)
+	if err := logging.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
+	}
+
+	if err := egress.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure egress policy: %v", err)
+	}
+	safefetch.Configure(cfg)
+
+	owners, err := tools.OpenOwnerStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open owner store: %v", err)
+	}
+	tools.SetOwnerStore(owners)
+	if records, _ := owners.List(); len(records) == 0 {
+		logging.Warnf("Owner registry is empty (OWNERSTORETYPE=%q); /api/v1/vehicle-lookup returns \"owner_id\": null until owners are added through /api/v1/admin/owners", cfg.OwnerStoreType)
+	}
+
+	if err := cassette.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure cassettes: %v", err)
+	}
+
+	if err := tools.ConfigureBackends(cfg); err != nil {
+		log.Fatalf("Failed to configure tool backends: %v", err)
+	}
+
+	if err := tools.ConfigureVehicleCache(cfg); err != nil {
+		log.Fatalf("Failed to configure DVSA cache: %v", err)
+	}
+
+	if err := alerts.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure alerting: %v", err)
+	}
+
+	if err := watchlist.Configure(cfg); err != nil {
+		log.Fatalf("Failed to open watchlists: %v", err)
+	}
+
+	if err := sightings.Configure(cfg); err != nil {
+		log.Fatalf("Failed to open sighting history: %v", err)
+	}
+
+	if err := parking.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure parking sessions: %v", err)
+	}
+
+	if err := access.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure gate access: %v", err)
+	}
+
+	if err := clonecheck.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure cloned plate detection: %v", err)
+	}
+
+	if err := caz.Configure(cfg); err != nil {
+		log.Fatalf("Failed to load clean air zones: %v", err)
+	}
+
+	if err := ratelimit.Configure(cfg); err != nil {
+		log.Fatalf("Failed to configure rate limit: %v", err)
+	}
+
+	holder := config.NewHolder(cfg)
+	reloads := &reloader{opts: opts, holder: holder}
+	go reloads.watch()
+
+	srv := api.NewServer(holder)
+	stopped := make(chan struct{})
+
+	// SIGHUP reloads the configuration. SIGINT and SIGTERM stop accepting
+	// requests, let those in flight and their webhooks finish, then
+	// snapshot the DVSA cache so a restart starts warm.
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range signals {
+			if sig == syscall.SIGHUP {
+				reloads.reload("SIGHUP")
+				continue
+			}
+			logging.Infof("Shutting down: waiting up to %s for requests in flight", shutdownTimeout)
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			if err := srv.Shutdown(ctx); err != nil {
+				logging.Errorf("Failed to stop server cleanly: %v", err)
+			}
+			if err := notify.Wait(ctx); err != nil {
+				logging.Errorf("Failed to finish webhook deliveries: %v", err)
+			}
+			cancel()
+			close(stopped)
+			return
+		}
+	}()
+
+	logging.Infof("Starting server on :8080")
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("could not start server: %v", err)
+	}
+	<-stopped
+	if err := tools.FlushVehicleCache(holder.Get()); err != nil {
+		logging.Errorf("Failed to save DVSA cache: %v", err)
+	}
 }
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/alerts"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"punkplod23/go-agent-ollama-slm/pkg/caz"
+	"punkplod23/go-agent-ollama-slm/pkg/clonecheck"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/ratelimit"
+	"punkplod23/go-agent-ollama-slm/pkg/safefetch"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveSettings are the packages configured again on every reload. Stores
+// and caches opened at startup are not in the list; their settings are
+// tagged reload:"restart" in config.Config.
+var liveSettings = []struct {
+	name      string
+	configure func(*config.Config) error
+}{
+	{"log level", logging.Configure},
+	{"egress policy", egress.Configure},
+	{"fetch limits", func(cfg *config.Config) error { safefetch.Configure(cfg); return nil }},
+	{"cassettes", cassette.Configure},
+	{"tool backends", tools.ConfigureBackends},
+	{"alerting", alerts.Configure},
+	{"cloned plate detection", clonecheck.Configure},
+	{"clean air zones", caz.Configure},
+	{"rate limit", ratelimit.Configure},
+}
+
+// applyLive configures every live package from cfg, stopping at the first failure.
+func applyLive(cfg *config.Config) error {
+	for _, s := range liveSettings {
+		if err := s.configure(cfg); err != nil {
+			return fmt.Errorf("failed to configure %s: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// reloader swaps in a new configuration from the same sources as startup.
+type reloader struct {
+	opts   *config.Options
+	holder *config.Holder
+	mu     sync.Mutex
+}
+
+// reload loads and applies the configuration. An invalid configuration, or
+// one a package refuses, is rejected and the running one is kept.
+func (r *reloader) reload(reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	running := r.holder.Get()
+	next, err := config.Load(r.opts)
+	if err != nil {
+		logging.Warnf("⚠️ Config reload (%s) rejected, keeping the running configuration: %v", reason, err)
+		return err
+	}
+	live, restart := config.Changes(running, next)
+	if len(live) == 0 && len(restart) == 0 {
+		// Still swap, as the set of secret files may have changed.
+		r.holder.Set(next)
+		logging.Infof("🔄 Config reload (%s): no changes", reason)
+		return nil
+	}
+	config.KeepRestartSettings(next, running)
+
+	if err := applyLive(next); err != nil {
+		if rollback := applyLive(running); rollback != nil {
+			logging.Errorf("❌ Failed to restore the running configuration: %v", rollback)
+		}
+		logging.Warnf("⚠️ Config reload (%s) rejected, keeping the running configuration: %v", reason, err)
+		return err
+	}
+	r.holder.Set(next)
+
+	if len(live) > 0 {
+		logging.Infof("🔄 Config reloaded (%s): %s", reason, strings.Join(live, ", "))
+	}
+	if len(restart) > 0 {
+		logging.Warnf("⚠️ Changed settings that need a restart were ignored: %s", strings.Join(restart, ", "))
+	}
+	return nil
+}
+
+// watch reloads when the config file or a secret file changes, which is
+// how a rotated token is picked up. Files are checked every
+// CONFIGRELOADINTERVAL by modification time and size.
+func (r *reloader) watch() {
+	last := filesStamp(r.holder.Get().Files())
+	for {
+		interval := r.holder.Get().ConfigReloadInterval
+		if interval <= 0 {
+			// Checking is off; look again later in case SIGHUP turns it on.
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(interval)
+		if stamp := filesStamp(r.holder.Get().Files()); stamp != last {
+			r.reload("file changed")
+			// Stamp what is watched now, as the reload may add or drop secret files.
+			last = filesStamp(r.holder.Get().Files())
+		}
+	}
+}
+
+// filesStamp identifies a version of a set of files. Stat follows symlinks,
+// so a Kubernetes secret volume swapping its data directory counts as a change.
+func filesStamp(paths []string) string {
+	var b strings.Builder
+	for _, path := range paths {
+		b.WriteString(path)
+		if info, err := os.Stat(path); err == nil {
+			fmt.Fprintf(&b, "=%d/%d", info.ModTime().UnixNano(), info.Size())
+		}
+		b.WriteString(";")
+	}
+	return b.String()
+}
@@ -0,0 +1,25 @@
+// Command fakeupstreams serves the ALPR and DVSA APIs from a fixtures
+// directory, so the service's real HTTP clients can be demonstrated with no
+// network:
+//
+//	go run ./cmd/fakeupstreams -addr :8090 -fixtures fixtures
+//	OPENALPRAPIURL=http://localhost:8090 DVSAAPIURL=http://localhost:8090/ go run ./cmd/app
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+)
+
+func main() {
+	addr := flag.String("addr", ":8090", "listen address")
+	dir := flag.String("fixtures", "fixtures", "fixtures directory")
+	flag.Parse()
+
+	log.Printf("🧪 Fake ALPR and DVSA listening on %s (fixtures in %s)", *addr, *dir)
+	if err := http.ListenAndServe(*addr, tools.Fixtures{Dir: *dir}.Handler()); err != nil {
+		log.Fatalf("could not start fake upstreams: %v", err)
+	}
+}
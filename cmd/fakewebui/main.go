@@ -0,0 +1,60 @@
+// Command fakewebui serves a fake Open WebUI for local development, so the
+// service can run without an Open WebUI and Ollama stack:
+//
+//	go run ./cmd/fakewebui -addr :3000 -script config/examples/fake-webui.json
+//	OPENWEBUIHOSTURL=http://localhost:3000 go run ./cmd/app
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"punkplod23/go-agent-ollama-slm/pkg/webui/webuitest"
+	"time"
+)
+
+// script is the file format for -script.
+type script struct {
+	Rules    []webuitest.Rule    `json:"rules"`
+	Failures []webuitest.Failure `json:"failures"`
+	Models   []string            `json:"models"`
+}
+
+func main() {
+	addr := flag.String("addr", ":3000", "listen address")
+	token := flag.String("token", "", "bearer token to require (default: accept any)")
+	scriptPath := flag.String("script", "", "JSON file of scripted replies and injected failures")
+	latency := flag.Duration("latency", 0, "delay added to every response")
+	completionDelay := flag.Duration("completion-delay", 2*time.Second, "time before a reply appears in the chat")
+	chunkDelay := flag.Duration("chunk-delay", 50*time.Millisecond, "delay between streamed chunks")
+	failRate := flag.Float64("fail-rate", 0, "share of all requests to fail with 503")
+	flag.Parse()
+
+	opts := webuitest.Options{
+		Token:           *token,
+		Latency:         *latency,
+		CompletionDelay: *completionDelay,
+		ChunkDelay:      *chunkDelay,
+	}
+	if *scriptPath != "" {
+		data, err := os.ReadFile(*scriptPath)
+		if err != nil {
+			log.Fatalf("Failed to read script: %v", err)
+		}
+		var s script
+		if err := json.Unmarshal(data, &s); err != nil {
+			log.Fatalf("Failed to parse script %s: %v", *scriptPath, err)
+		}
+		opts.Rules, opts.Failures, opts.Models = s.Rules, s.Failures, s.Models
+	}
+	if *failRate > 0 {
+		opts.Failures = append(opts.Failures, webuitest.Failure{Path: "/api/*", Status: http.StatusServiceUnavailable, Rate: *failRate})
+	}
+
+	log.Printf("🧪 Fake Open WebUI listening on %s (%d scripted replies)", *addr, len(opts.Rules))
+	if err := http.ListenAndServe(*addr, webuitest.New(opts)); err != nil {
+		log.Fatalf("could not start fake Open WebUI: %v", err)
+	}
+}
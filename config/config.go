@@ -1,26 +1,131 @@
 package config
 
 import (
-	"os"
+	"time"
 )
 
+// Config holds every setting. Each field's env tag names its environment
+// variable, which is also its key in a config file and, lower-cased, its
+// command-line flag; default gives the value used when no source sets it.
+// Fields tagged secret are masked by --print-config and can instead be read
+// from a file named by <ENV>_FILE, e.g. OPENWEBUIAPITOKEN_FILE. Fields tagged
+// reload:"restart" open stores or caches at startup, so a changed value only
+// takes effect after a restart; every other setting is swapped in on reload.
 type Config struct {
-	OpenWebUIHostURL   string
-	OpenWebUIToken     string
-	OpenWebUIModelName string
-	DVSAAPIURL         string
-	OpenALPRAPIURL     string
-	TempDirPath        string
+	OpenWebUIHostURL   string `env:"OPENWEBUIHOSTURL"`
+	OpenWebUIToken     string `env:"OPENWEBUIAPITOKEN" secret:"true"`
+	OpenWebUIModelName string `env:"OPENWEBUIMODELNAME"`
+	DVSAAPIURL         string `env:"DVSAAPIURL"`
+	OpenALPRAPIURL     string `env:"OPENALPRAPIURL"`
+	TempDirPath        string `env:"TEMPDIRPATH"`
+
+	// Tools enabled on every Open WebUI chat (comma-separated tool names; empty for none).
+	OpenWebUITools string `env:"OPENWEBUITOOLS" default:"DVSA Lookup"`
+
+	// Models a chat request may pick (comma-separated), besides OPENWEBUIMODELNAME and the models named below.
+	OpenWebUIModels string `env:"OPENWEBUIMODELS"`
+	// Named model choices, e.g. "fast=phi3:mini,accurate=llama3:70b|llama3:8b"; "|" separates a fallback chain.
+	ModelAliases string `env:"MODELALIASES"`
+	// Models tried in order when the chosen model errors or times out (comma-separated).
+	ModelFallbacks string `env:"MODELFALLBACKS"`
+	// How long one model may take to accept a completion before the next is tried.
+	ModelTimeout time.Duration `env:"MODELTIMEOUT" default:"60s"`
+
+	// Ollama server behind Open WebUI, when reachable directly; adds size, family and context length to /api/v1/models.
+	OllamaURL string `env:"OLLAMAURL"`
+
+	// How often the config file is checked for changes (0 disables the check; SIGHUP always reloads).
+	ConfigReloadInterval time.Duration `env:"CONFIGRELOADINTERVAL" default:"10s"`
+
+	// Log verbosity: "debug" (also dumps Open WebUI requests), "info", "warn" or "error".
+	LogLevel string `env:"LOGLEVEL" default:"info"`
+
+	// Per-client request rate limit on the API (requests per second, 0 disables it) and burst size.
+	RateLimitRPS   float64 `env:"RATELIMITRPS"`
+	RateLimitBurst int     `env:"RATELIMITBURST" default:"20"`
+
+	// Tool backends: "remote" (default) calls the real APIs, "offline"
+	// answers from the fixtures directory.
+	ALPRBackend string `env:"ALPRBACKEND"`
+	DVSABackend string `env:"DVSABACKEND"`
+	FixturesDir string `env:"FIXTURESDIR" default:"fixtures"`
+
+	// Upstream cassettes: "record" saves every Open WebUI, ALPR and DVSA
+	// exchange under CassetteDir, "replay" answers from them offline.
+	CassetteMode string `env:"CASSETTEMODE"`
+	CassetteDir  string `env:"CASSETTEDIR" default:"testdata/cassettes"`
+
+	// Image upload limits applied before anything is sent to the ALPR backend.
+	MaxImageBytes  int64 `env:"MAXIMAGEBYTES" default:"10485760"` // 10 MB
+	MaxImageWidth  int   `env:"MAXIMAGEWIDTH" default:"8192"`
+	MaxImageHeight int   `env:"MAXIMAGEHEIGHT" default:"8192"`
+
+	// Registration-to-owner registry: "memory" (default), "csv", "json" or "kv".
+	OwnerStoreType string `env:"OWNERSTORETYPE" reload:"restart"`
+	OwnerStorePath string `env:"OWNERSTOREPATH" reload:"restart"`
+
+	// DVSA lookup cache. A size of 0 disables caching; a path enables disk snapshots.
+	DVSACacheSize        int           `env:"DVSACACHESIZE" default:"1000" reload:"restart"`
+	DVSACacheTTL         time.Duration `env:"DVSACACHETTL" default:"1h" reload:"restart"`
+	DVSACacheNegativeTTL time.Duration `env:"DVSACACHENEGATIVETTL" default:"10m" reload:"restart"`
+	DVSACachePath        string        `env:"DVSACACHEPATH" reload:"restart"`
+
+	// Compliance alerting: rules file (JSON) and sink ("log", "file" or "webhook").
+	// A rule alerts a registration at most once per cooldown.
+	AlertRulesPath  string        `env:"ALERTRULESPATH"`
+	AlertSink       string        `env:"ALERTSINK"`
+	AlertSinkTarget string        `env:"ALERTSINKTARGET"`
+	AlertCooldown   time.Duration `env:"ALERTCOOLDOWN" default:"24h"`
+
+	// Plate watchlists: store location (in memory when empty) and default hit webhook.
+	WatchlistStorePath  string `env:"WATCHLISTSTOREPATH" reload:"restart"`
+	WatchlistWebhookURL string `env:"WATCHLISTWEBHOOKURL" reload:"restart"`
+
+	// Sighting history: store location (in memory when empty) and how long to keep reads (0 keeps them forever,
+	// which lets the store grow without bound).
+	SightingStorePath string        `env:"SIGHTINGSTOREPATH" reload:"restart"`
+	SightingRetention time.Duration `env:"SIGHTINGRETENTION" default:"720h" reload:"restart"`
+
+	// Parking sessions: JSON file describing sites and their entry/exit cameras, and the session store location.
+	ParkingSitesPath string `env:"PARKINGSITESPATH" reload:"restart"`
+	ParkingStorePath string `env:"PARKINGSTOREPATH" reload:"restart"`
+
+	// Gate access: allow-list and audit store, time zone for grant windows, minimum OCR confidence and gate controller webhook.
+	AccessStorePath     string  `env:"ACCESSSTOREPATH" reload:"restart"`
+	AccessTimezone      string  `env:"ACCESSTIMEZONE" reload:"restart"`
+	AccessMinConfidence float64 `env:"ACCESSMINCONFIDENCE" reload:"restart"`
+	GateWebhookURL      string  `env:"GATEWEBHOOKURL" reload:"restart"`
+
+	// Cloned plate detection: classifier ("vision", "http" or empty to disable), its URL or vision model, and the suspicion threshold (0-1).
+	CloneClassifier     string  `env:"CLONECLASSIFIER"`
+	CloneClassifierURL  string  `env:"CLONECLASSIFIERURL"`
+	CloneVisionModel    string  `env:"CLONEVISIONMODEL"`
+	CloneScoreThreshold float64 `env:"CLONESCORETHRESHOLD" default:"0.5"`
+
+	// JSON file of clean air zones; built-in defaults are used when empty.
+	CAZZonesPath string `env:"CAZZONESPATH"`
+
+	// Egress policy for every outbound connection (comma-separated lists). Hosts are "name" or "name=ip|ip";
+	// when empty, the hosts of the configured upstream URLs are allowed.
+	EgressAllowedCIDRs string `env:"EGRESSALLOWEDCIDRS"`
+	EgressBlockedCIDRs string `env:"EGRESSBLOCKEDCIDRS"`
+	EgressAllowedHosts string `env:"EGRESSALLOWEDHOSTS"`
+	EgressAllowedPorts string `env:"EGRESSALLOWEDPORTS"`
+
+	// Limits for fetching caller-supplied URLs (image_url, content_url, callbacks).
+	FetchMaxBytes     int64         `env:"FETCHMAXBYTES" default:"10485760"` // 10 MB
+	FetchTimeout      time.Duration `env:"FETCHTIMEOUT" default:"15s"`
+	FetchMaxRedirects int           `env:"FETCHMAXREDIRECTS" default:"3"`
+
+	// Bearer token required by /api/v1/admin endpoints. Admin endpoints are disabled when empty.
+	AdminAPIToken string `env:"ADMINAPITOKEN" secret:"true"`
+
+	// files are the config file and secret files the settings were read from.
+	files []string
 }
 
+// LoadConfigFromEnv loads the configuration from the environment, and from
+// the file named by CONFIGFILE when set, and validates it.
 func LoadConfigFromEnv() (*Config, error) {
-
-	return &Config{
-		OpenWebUIHostURL:   os.Getenv("OPENWEBUIHOSTURL"),
-		OpenWebUIToken:     os.Getenv("OPENWEBUIAPITOKEN"),
-		OpenWebUIModelName: os.Getenv("OPENWEBUIMODELNAME"),
-		DVSAAPIURL:         os.Getenv("DVSAAPIURL"),
-		OpenALPRAPIURL:     os.Getenv("OPENALPRAPIURL"),
-		TempDirPath:        os.Getenv("TEMPDIRPATH"),
-	}, nil
+	return Load(&Options{})
 }
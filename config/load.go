@@ -0,0 +1,469 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sources, from lowest to highest precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// fileSuffix names the variant of a secret setting that holds a path to a
+// file containing the value, as mounted from a Kubernetes secret volume.
+const fileSuffix = "_FILE"
+
+// Options control where configuration is read from.
+type Options struct {
+	// File is a YAML or JSON config file; CONFIGFILE is used when empty.
+	File string
+	// PrintConfig asks the caller to print the configuration and exit.
+	PrintConfig bool
+	// Flags holds settings given on the command line, keyed by env name.
+	Flags map[string]string
+}
+
+// setting is one Config field.
+type setting struct {
+	Env     string
+	Index   int
+	Secret  bool
+	Restart bool
+	Def     string
+}
+
+func settings() []setting {
+	t := reflect.TypeOf(Config{})
+	var list []setting
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		env := f.Tag.Get("env")
+		if env == "" {
+			continue
+		}
+		list = append(list, setting{
+			Env:     env,
+			Index:   i,
+			Secret:  f.Tag.Get("secret") == "true",
+			Restart: f.Tag.Get("reload") == "restart",
+			Def:     f.Tag.Get("default"),
+		})
+	}
+	return list
+}
+
+// canonicalKey folds a file key or flag name onto an env name, so
+// "openwebui_host_url", "openwebuihosturl" and "OPENWEBUIHOSTURL" all match.
+func canonicalKey(key string) string {
+	return strings.ToUpper(strings.NewReplacer("_", "", "-", "").Replace(strings.TrimSpace(key)))
+}
+
+// ConfigFile returns the config file to read: File, or CONFIGFILE when
+// File is empty. It is "" when configuration comes only from env and flags.
+func (o *Options) ConfigFile() string {
+	if o == nil {
+		return os.Getenv("CONFIGFILE")
+	}
+	if o.File != "" {
+		return o.File
+	}
+	return os.Getenv("CONFIGFILE")
+}
+
+// ParseFlags reads command-line arguments: --config FILE, --print-config,
+// and one flag per setting named after its env variable in lower case,
+// e.g. --openwebuihosturl.
+func ParseFlags(args []string) (*Options, error) {
+	opts := &Options{Flags: map[string]string{}}
+	fs := flag.NewFlagSet("app", flag.ContinueOnError)
+	fs.StringVar(&opts.File, "config", "", "YAML or JSON config file (default: $CONFIGFILE)")
+	fs.BoolVar(&opts.PrintConfig, "print-config", false, "print the effective configuration with secrets masked, then exit")
+	for _, s := range settings() {
+		env := s.Env
+		fs.Func(strings.ToLower(env), "sets "+env, func(v string) error {
+			opts.Flags[env] = v
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return opts, nil
+}
+
+// Load builds the configuration from defaults, the config file, the
+// environment and flags, each overriding the one before, and validates it.
+func Load(opts *Options) (*Config, error) {
+	cfg, _, err := load(opts)
+	return cfg, err
+}
+
+func load(opts *Options) (*Config, map[string]string, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	cfg := &Config{}
+	sources := map[string]string{}
+	v := reflect.ValueOf(cfg).Elem()
+	var errs []error
+
+	set := func(s setting, raw, source string) {
+		if err := setField(v.Field(s.Index), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s (from %s): %w", s.Env, source, err))
+			return
+		}
+		sources[s.Env] = source
+	}
+	setFromFile := func(s setting, path, source string) {
+		raw, err := readSecret(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s%s (from %s): %w", s.Env, fileSuffix, source, err))
+			return
+		}
+		cfg.files = append(cfg.files, path)
+		set(s, raw, source+" via "+path)
+	}
+
+	list := settings()
+	for _, s := range list {
+		set(s, s.Def, SourceDefault)
+	}
+
+	if path := opts.ConfigFile(); path != "" {
+		values, err := readFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.files = append(cfg.files, path)
+		byEnv, byFileKey := map[string]setting{}, map[string]setting{}
+		for _, s := range list {
+			byEnv[s.Env] = s
+			if s.Secret {
+				byFileKey[canonicalKey(s.Env+fileSuffix)] = s
+			}
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		seen := map[string]string{}
+		for _, k := range keys {
+			s, ok := byEnv[canonicalKey(k)]
+			fromFile := false
+			if !ok {
+				s, fromFile = byFileKey[canonicalKey(k)]
+			}
+			if !ok && !fromFile {
+				errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, k))
+				continue
+			}
+			if first, dup := seen[s.Env]; dup {
+				errs = append(errs, fmt.Errorf("%s: %q and %q both set %s", path, first, k, s.Env))
+				continue
+			}
+			seen[s.Env] = k
+			if fromFile {
+				setFromFile(s, values[k], SourceFile)
+			} else {
+				set(s, values[k], SourceFile)
+			}
+		}
+	}
+
+	for _, s := range list {
+		raw, path := os.Getenv(s.Env), ""
+		if s.Secret {
+			path = os.Getenv(s.Env + fileSuffix)
+		}
+		switch {
+		case raw != "" && path != "":
+			errs = append(errs, fmt.Errorf("set only one of %s and %s%s in the environment", s.Env, s.Env, fileSuffix))
+		case path != "":
+			setFromFile(s, path, SourceEnv)
+		case raw != "":
+			set(s, raw, SourceEnv)
+		}
+	}
+	for _, s := range list {
+		if raw, ok := opts.Flags[s.Env]; ok {
+			set(s, raw, SourceFlag)
+		}
+	}
+
+	if len(errs) == 0 {
+		errs = cfg.validate()
+	}
+	if len(errs) > 0 {
+		return nil, nil, fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return cfg, sources, nil
+}
+
+func setField(f reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch {
+	case f.Type() == reflect.TypeOf(time.Duration(0)):
+		if raw == "" {
+			f.SetInt(0)
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 90s or 1h", raw)
+		}
+		f.SetInt(int64(d))
+	case f.Kind() == reflect.String:
+		f.SetString(raw)
+	case f.Kind() == reflect.Int || f.Kind() == reflect.Int64:
+		if raw == "" {
+			f.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		f.SetInt(n)
+	case f.Kind() == reflect.Float64:
+		if raw == "" {
+			f.SetFloat(0)
+			return nil
+		}
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		f.SetFloat(n)
+	case f.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		f.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported setting type %s", f.Type())
+	}
+	return nil
+}
+
+// --- Config files ---
+
+// readSecret reads a secret from a file, ignoring surrounding whitespace such
+// as the trailing newline most tools write.
+func readSecret(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "" {
+		return "", fmt.Errorf("secret file %s is empty", path)
+	}
+	return value, nil
+}
+
+// readFile reads a flat JSON object, or a YAML file of "key: value" lines.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".json" || (ext != ".yaml" && ext != ".yml" && bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))) {
+		values, err := parseJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		return values, nil
+	}
+	values, err := parseYAML(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return values, nil
+}
+
+func parseJSON(data []byte) (map[string]string, error) {
+	var raw map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for k, v := range raw {
+		switch t := v.(type) {
+		case string:
+			values[k] = t
+		case json.Number:
+			values[k] = t.String()
+		case bool:
+			values[k] = strconv.FormatBool(t)
+		case nil:
+			values[k] = ""
+		case []interface{}:
+			// Lists are joined, for the comma-separated settings.
+			parts := make([]string, 0, len(t))
+			for _, item := range t {
+				parts = append(parts, fmt.Sprint(item))
+			}
+			values[k] = strings.Join(parts, ",")
+		default:
+			return nil, fmt.Errorf("%s: nested objects are not supported; use flat keys", k)
+		}
+	}
+	return values, nil
+}
+
+// parseYAML reads the subset of YAML a flat config needs: "key: value"
+// lines, # comments, quoted values and "- item" lists, which are joined
+// with commas.
+func parseYAML(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo, listKey := 0, ""
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item outside a list", lineNo)
+			}
+			item, err := yamlScalar(strings.TrimPrefix(trimmed, "- "))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if values[listKey] != "" {
+				values[listKey] += ","
+			}
+			values[listKey] += item
+			continue
+		}
+		if line != trimmed {
+			return nil, fmt.Errorf("line %d: nested settings are not supported; use flat keys", lineNo)
+		}
+
+		key, rest, ok := strings.Cut(trimmed, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if _, dup := values[key]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", lineNo, key)
+		}
+		value, err := yamlScalar(rest)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		values[key] = value
+		listKey = ""
+		if value == "" {
+			listKey = key
+		}
+	}
+	return values, scanner.Err()
+}
+
+func yamlScalar(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	switch s[0] {
+	case '"':
+		v, err := strconv.Unquote(s[:closingQuote(s, '"')+1])
+		if err != nil {
+			return "", fmt.Errorf("bad double-quoted value %s", s)
+		}
+		return v, nil
+	case '\'':
+		end := closingQuote(s, '\'')
+		if end <= 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", s)
+		}
+		return strings.ReplaceAll(s[1:end], "''", "'"), nil
+	case '{', '[', '|', '>', '&', '*':
+		return "", fmt.Errorf("unsupported YAML value %s: only plain and quoted scalars are supported", s)
+	}
+	// An unquoted value ends at a comment.
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
+
+// closingQuote returns the index of the quote ending s, or -1.
+func closingQuote(s string, q byte) int {
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case q == '\'' && s[i] == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++
+		case s[i] == q:
+			return i
+		}
+	}
+	return -1
+}
+
+// --- Printing ---
+
+// Print writes the configuration as YAML that Load can read back, with
+// secrets masked and the source of each value noted.
+func Print(w io.Writer, opts *Options) error {
+	cfg, sources, err := load(opts)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(cfg).Elem()
+	fmt.Fprintln(w, "# Effective configuration (precedence: flag > env > file > default)")
+	for _, s := range settings() {
+		value := formatField(v.Field(s.Index))
+		if s.Secret && value != "" {
+			value = "********"
+		} else {
+			value = maskURLPassword(value)
+		}
+		fmt.Fprintf(w, "%s: %s # %s\n", s.Env, strconv.Quote(value), sources[s.Env])
+	}
+	return nil
+}
+
+func formatField(f reflect.Value) string {
+	switch {
+	case f.Type() == reflect.TypeOf(time.Duration(0)):
+		if f.Int() == 0 {
+			return ""
+		}
+		return time.Duration(f.Int()).String()
+	case f.Kind() == reflect.Float64:
+		return strconv.FormatFloat(f.Float(), 'f', -1, 64)
+	}
+	return fmt.Sprint(f.Interface())
+}
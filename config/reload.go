@@ -0,0 +1,59 @@
+package config
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Holder holds the configuration in force. Reloading swaps in a new
+// *Config; a Config is never modified once stored, so callers can keep
+// the pointer they got for the rest of a request.
+type Holder struct {
+	current atomic.Pointer[Config]
+}
+
+// NewHolder returns a Holder serving cfg.
+func NewHolder(cfg *Config) *Holder {
+	h := &Holder{}
+	h.current.Store(cfg)
+	return h
+}
+
+// Get returns the configuration in force.
+func (h *Holder) Get() *Config { return h.current.Load() }
+
+// Set replaces the configuration in force.
+func (h *Holder) Set(cfg *Config) { h.current.Store(cfg) }
+
+// Files returns the config file and secret files the configuration was read
+// from, so they can be watched for changes.
+func (c *Config) Files() []string { return c.files }
+
+// Changes compares two configurations and returns the env names of the
+// settings that differ, split into those applied on reload and those
+// tagged reload:"restart".
+func Changes(old, next *Config) (live, restart []string) {
+	ov, nv := reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem()
+	for _, s := range settings() {
+		if reflect.DeepEqual(ov.Field(s.Index).Interface(), nv.Field(s.Index).Interface()) {
+			continue
+		}
+		if s.Restart {
+			restart = append(restart, s.Env)
+		} else {
+			live = append(live, s.Env)
+		}
+	}
+	return live, restart
+}
+
+// KeepRestartSettings copies the restart-only settings of running into
+// next, so the reloaded configuration describes what is actually in use.
+func KeepRestartSettings(next, running *Config) {
+	nv, rv := reflect.ValueOf(next).Elem(), reflect.ValueOf(running).Elem()
+	for _, s := range settings() {
+		if s.Restart {
+			nv.Field(s.Index).Set(rv.Field(s.Index))
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// validate checks the configuration and returns every problem found, so
+// they can be fixed in one go.
+func (c *Config) validate() []error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	// Open WebUI is needed for every chat, so it is always required.
+	if c.OpenWebUIHostURL == "" {
+		fail("OPENWEBUIHOSTURL is required: the base URL of Open WebUI, e.g. http://open-webui:8080")
+	} else if err := checkURL(c.OpenWebUIHostURL); err != nil {
+		fail("OPENWEBUIHOSTURL: %v", err)
+	} else if strings.HasSuffix(c.OpenWebUIHostURL, "/") {
+		fail("OPENWEBUIHOSTURL must not end with \"/\": paths such as /api/v1/chats are appended to it")
+	}
+	if c.OpenWebUIToken == "" {
+		fail("OPENWEBUIAPITOKEN is required: an Open WebUI API key (Settings > Account > API keys)")
+	}
+	if c.OpenWebUIModelName == "" {
+		fail("OPENWEBUIMODELNAME is required: the model chats are sent to, e.g. llama3:8b")
+	}
+
+	for _, entry := range splitList(c.ModelAliases) {
+		name, models, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(name) == "" || strings.TrimSpace(models) == "" {
+			fail("MODELALIASES entry %q is not valid: use name=model or name=model|fallback", entry)
+			continue
+		}
+		for _, m := range strings.Split(models, "|") {
+			if strings.TrimSpace(m) == "" {
+				fail("MODELALIASES entry %q has an empty model in its chain", entry)
+				break
+			}
+		}
+	}
+	if c.OllamaURL != "" {
+		if err := checkURL(c.OllamaURL); err != nil {
+			fail("OLLAMAURL: %v", err)
+		}
+	}
+	if c.ModelTimeout <= 0 {
+		fail("MODELTIMEOUT must be positive")
+	}
+
+	if !oneOf(c.LogLevel, "", "debug", "info", "warn", "error") {
+		fail("LOGLEVEL %q is not valid: use debug, info, warn or error", c.LogLevel)
+	}
+	if c.ConfigReloadInterval < 0 {
+		fail("CONFIGRELOADINTERVAL must not be negative")
+	}
+	if c.RateLimitRPS < 0 {
+		fail("RATELIMITRPS must not be negative")
+	} else if c.RateLimitRPS > 0 && c.RateLimitBurst < 1 {
+		fail("RATELIMITBURST must be at least 1 when RATELIMITRPS is set")
+	}
+
+	if !oneOf(c.ALPRBackend, "", "remote", "offline") {
+		fail("ALPRBACKEND %q is not valid: use remote or offline", c.ALPRBackend)
+	}
+	if !oneOf(c.DVSABackend, "", "remote", "offline") {
+		fail("DVSABACKEND %q is not valid: use remote or offline", c.DVSABackend)
+	}
+	if !strings.EqualFold(c.ALPRBackend, "offline") {
+		switch {
+		case c.OpenALPRAPIURL == "":
+			fail("OPENALPRAPIURL is required unless ALPRBACKEND=offline")
+		case checkURL(c.OpenALPRAPIURL) != nil:
+			fail("OPENALPRAPIURL: %v", checkURL(c.OpenALPRAPIURL))
+		case strings.HasSuffix(c.OpenALPRAPIURL, "/"):
+			fail("OPENALPRAPIURL must not end with \"/\": /process-base64-image/ is appended to it")
+		}
+	}
+	if !strings.EqualFold(c.DVSABackend, "offline") {
+		switch {
+		case c.DVSAAPIURL == "":
+			fail("DVSAAPIURL is required unless DVSABACKEND=offline")
+		case checkURL(c.DVSAAPIURL) != nil:
+			fail("DVSAAPIURL: %v", checkURL(c.DVSAAPIURL))
+		case !strings.HasSuffix(c.DVSAAPIURL, "/"):
+			fail("DVSAAPIURL must end with \"/\": vehicle-enquiry/v1/vehicles/{registration} is appended to it")
+		}
+	}
+	if !oneOf(c.CassetteMode, "", "record", "replay") {
+		fail("CASSETTEMODE %q is not valid: use record, replay or leave it empty", c.CassetteMode)
+	}
+
+	if c.MaxImageBytes <= 0 {
+		fail("MAXIMAGEBYTES must be positive")
+	}
+	if c.MaxImageWidth < 0 || c.MaxImageHeight < 0 {
+		fail("MAXIMAGEWIDTH and MAXIMAGEHEIGHT must not be negative")
+	}
+
+	switch strings.ToLower(c.OwnerStoreType) {
+	case "", "memory":
+	case "csv", "json", "kv":
+		if c.OwnerStorePath == "" {
+			fail("OWNERSTOREPATH is required when OWNERSTORETYPE=%s", c.OwnerStoreType)
+		}
+	default:
+		fail("OWNERSTORETYPE %q is not valid: use memory, csv, json or kv", c.OwnerStoreType)
+	}
+
+	if c.DVSACacheSize < 0 || c.DVSACacheTTL < 0 || c.DVSACacheNegativeTTL < 0 {
+		fail("DVSACACHESIZE, DVSACACHETTL and DVSACACHENEGATIVETTL must not be negative")
+	}
+
+	switch c.AlertSink {
+	case "", "log":
+	case "file":
+		if c.AlertSinkTarget == "" {
+			fail("ALERTSINKTARGET is required when ALERTSINK=file: the file alerts are appended to")
+		}
+	case "webhook":
+		if c.AlertSinkTarget == "" {
+			fail("ALERTSINKTARGET is required when ALERTSINK=webhook: the URL alerts are posted to")
+		} else if err := checkURL(c.AlertSinkTarget); err != nil {
+			fail("ALERTSINKTARGET: %v", err)
+		}
+	default:
+		fail("ALERTSINK %q is not valid: use log, file or webhook", c.AlertSink)
+	}
+	if c.AlertCooldown < 0 {
+		fail("ALERTCOOLDOWN must not be negative")
+	}
+
+	if c.WatchlistWebhookURL != "" {
+		if err := checkURL(c.WatchlistWebhookURL); err != nil {
+			fail("WATCHLISTWEBHOOKURL: %v", err)
+		}
+	}
+	if c.GateWebhookURL != "" {
+		if err := checkURL(c.GateWebhookURL); err != nil {
+			fail("GATEWEBHOOKURL: %v", err)
+		}
+	}
+
+	if c.SightingRetention < 0 {
+		fail("SIGHTINGRETENTION must not be negative")
+	}
+	if c.AccessTimezone != "" {
+		if _, err := time.LoadLocation(c.AccessTimezone); err != nil {
+			fail("ACCESSTIMEZONE %q is not a known time zone, e.g. Europe/London", c.AccessTimezone)
+		}
+	}
+	if c.AccessMinConfidence < 0 || c.AccessMinConfidence > 1 {
+		fail("ACCESSMINCONFIDENCE must be between 0 and 1")
+	}
+
+	switch strings.ToLower(c.CloneClassifier) {
+	case "", "vision":
+	case "http":
+		if c.CloneClassifierURL == "" {
+			fail("CLONECLASSIFIERURL is required when CLONECLASSIFIER=http")
+		} else if err := checkURL(c.CloneClassifierURL); err != nil {
+			fail("CLONECLASSIFIERURL: %v", err)
+		}
+	default:
+		fail("CLONECLASSIFIER %q is not valid: use vision, http or leave it empty", c.CloneClassifier)
+	}
+	if c.CloneScoreThreshold < 0 || c.CloneScoreThreshold > 1 {
+		fail("CLONESCORETHRESHOLD must be between 0 and 1")
+	}
+
+	if c.FetchMaxBytes <= 0 || c.FetchTimeout <= 0 || c.FetchMaxRedirects < 0 {
+		fail("FETCHMAXBYTES and FETCHTIMEOUT must be positive and FETCHMAXREDIRECTS must not be negative")
+	}
+	return errs
+}
+
+// checkURL requires an absolute http or https URL.
+func checkURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid URL: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
+
+// splitList splits a comma-separated setting, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func oneOf(v string, options ...string) bool {
+	for _, o := range options {
+		if strings.EqualFold(v, o) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskURLPassword hides the password in a URL with credentials.
+func maskURLPassword(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return value
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "********")
+		return u.String()
+	}
+	return value
+}
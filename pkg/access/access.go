@@ -0,0 +1,389 @@
+// Package access decides whether a vehicle may pass a gate by checking its
+// plate against an allow-list of grants with validity dates and time windows.
+// Every decision is kept for audit.
+package access
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/instance"
+	"punkplod23/go-agent-ollama-slm/pkg/kvstore"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/notify"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned for unknown grants.
+var ErrNotFound = errors.New("not found")
+
+// Decision outcomes.
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// ReasonALPRFailed is the reason recorded when the gate image could not be read.
+const ReasonALPRFailed = "alpr_failed"
+
+// Window is a recurring period during which a grant is valid. From and To
+// are "HH:MM" in the access time zone; a window whose To is earlier than its
+// From runs past midnight. Days lists weekdays as "mon".."sun" and is empty
+// for every day.
+type Window struct {
+	Days []string `json:"days,omitempty"`
+	From string   `json:"from"`
+	To   string   `json:"to"`
+}
+
+// Grant allows a plate through the gates.
+type Grant struct {
+	Plate      string     `json:"plate"`
+	Holder     string     `json:"holder,omitempty"`
+	Gates      []string   `json:"gates,omitempty"` // empty means every gate
+	ValidFrom  *time.Time `json:"valid_from,omitempty"`
+	ValidUntil *time.Time `json:"valid_until,omitempty"`
+	Windows    []Window   `json:"windows,omitempty"` // empty means at any time
+}
+
+// Decision is the audited outcome of a gate request.
+type Decision struct {
+	ID         string    `json:"id"`
+	GateID     string    `json:"gate_id,omitempty"`
+	Decision   string    `json:"decision"`
+	Plate      string    `json:"plate,omitempty"`
+	ReadText   string    `json:"read_text,omitempty"`
+	Confidence float64   `json:"confidence,omitempty"`
+	Holder     string    `json:"holder,omitempty"`
+	Reasons    []string  `json:"reasons"`
+	DecidedAt  time.Time `json:"decided_at"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseClock(v string) (int, error) {
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q: use HH:MM", v)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Validate checks the grant's plate, dates and windows.
+func (g *Grant) Validate() error {
+	if g.Plate == "" {
+		return fmt.Errorf("plate is required")
+	}
+	if g.ValidFrom != nil && g.ValidUntil != nil && !g.ValidUntil.After(*g.ValidFrom) {
+		return fmt.Errorf("valid_until must be after valid_from")
+	}
+	for i, w := range g.Windows {
+		for _, d := range w.Days {
+			if _, ok := weekdays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("window %d: unknown day %q", i+1, d)
+			}
+		}
+		from, err := parseClock(w.From)
+		if err != nil {
+			return fmt.Errorf("window %d: %w", i+1, err)
+		}
+		to, err := parseClock(w.To)
+		if err != nil {
+			return fmt.Errorf("window %d: %w", i+1, err)
+		}
+		if from == to {
+			return fmt.Errorf("window %d: from and to are the same", i+1)
+		}
+	}
+	return nil
+}
+
+// contains reports whether t, already in the access time zone, falls in the window.
+func (w Window) contains(t time.Time) bool {
+	from, _ := parseClock(w.From)
+	to, _ := parseClock(w.To)
+	minute := t.Hour()*60 + t.Minute()
+
+	day := t.Weekday()
+	switch {
+	case from < to:
+		if minute < from || minute >= to {
+			return false
+		}
+	case minute >= from:
+		// Overnight window, before midnight.
+	case minute < to:
+		// Overnight window, after midnight: it belongs to the previous day.
+		day = (day + 6) % 7
+	default:
+		return false
+	}
+
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports whether the grant admits a vehicle at gate at time now, with
+// the reason when it does not.
+func (g *Grant) Check(gate string, now time.Time, loc *time.Location) (bool, string) {
+	if g.ValidFrom != nil && now.Before(*g.ValidFrom) {
+		return false, fmt.Sprintf("grant for %s is not valid until %s", g.Plate, g.ValidFrom.Format(time.RFC3339))
+	}
+	if g.ValidUntil != nil && !now.Before(*g.ValidUntil) {
+		return false, fmt.Sprintf("grant for %s expired at %s", g.Plate, g.ValidUntil.Format(time.RFC3339))
+	}
+	if len(g.Gates) > 0 {
+		allowed := false
+		for _, id := range g.Gates {
+			allowed = allowed || id == gate
+		}
+		if !allowed {
+			return false, fmt.Sprintf("grant for %s does not cover gate %q", g.Plate, gate)
+		}
+	}
+	if len(g.Windows) > 0 {
+		local := now.In(loc)
+		inWindow := false
+		for _, w := range g.Windows {
+			inWindow = inWindow || w.contains(local)
+		}
+		if !inWindow {
+			return false, fmt.Sprintf("%s is outside the permitted hours for %s", local.Format("Mon 15:04"), g.Plate)
+		}
+	}
+	return true, fmt.Sprintf("%s has a valid grant", g.Plate)
+}
+
+// Controller holds the allow-list and the decision audit log.
+type Controller struct {
+	db            *kvstore.Store
+	loc           *time.Location
+	minConfidence float64
+	webhookURL    string
+}
+
+const (
+	grantPrefix    = "grant/"
+	decisionPrefix = "decision/"
+	// decisionTimeLayout sorts lexically in time order.
+	decisionTimeLayout = "20060102T150405.000000000Z"
+)
+
+// Open opens the access store at path; an empty path keeps it in memory.
+// Time windows are evaluated in loc.
+func Open(path string, loc *time.Location) (*Controller, error) {
+	db, err := kvstore.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open access store: %w", err)
+	}
+	if loc == nil {
+		loc = time.Local
+	}
+	return &Controller{db: db, loc: loc}, nil
+}
+
+// PutGrant adds or replaces the grant for a plate.
+func (c *Controller) PutGrant(g Grant) (*Grant, error) {
+	g.Plate = tools.NormalisePlate(g.Plate)
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	if err := c.db.Put(grantPrefix+g.Plate, g); err != nil {
+		return nil, err
+	}
+	return &g, nil
+}
+
+// Grant returns the grant for a plate.
+func (c *Controller) Grant(plate string) (*Grant, error) {
+	var g Grant
+	found, err := c.db.Get(grantPrefix+tools.NormalisePlate(plate), &g)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("grant for %s: %w", plate, ErrNotFound)
+	}
+	return &g, nil
+}
+
+// DeleteGrant removes the grant for a plate.
+func (c *Controller) DeleteGrant(plate string) error {
+	if _, err := c.Grant(plate); err != nil {
+		return err
+	}
+	return c.db.Delete(grantPrefix + tools.NormalisePlate(plate))
+}
+
+// Grants returns every grant ordered by plate.
+func (c *Controller) Grants() ([]Grant, error) {
+	grants := []Grant{}
+	var decodeErr error
+	c.db.Scan(grantPrefix, func(_ string, v json.RawMessage) bool {
+		var g Grant
+		if decodeErr = json.Unmarshal(v, &g); decodeErr != nil {
+			return false
+		}
+		grants = append(grants, g)
+		return true
+	})
+	return grants, decodeErr
+}
+
+// Decide checks every plate read at a gate and allows entry if any of them
+// holds a valid grant. Only exact reads are accepted: an OCR-confusion
+// candidate is never enough to open a gate. The decision is stored for audit.
+func (c *Controller) Decide(gate string, results []tools.ALPRResult, now time.Time) (*Decision, error) {
+	d := Decision{ID: uuid.New().String(), GateID: gate, Decision: Deny, Reasons: []string{}, DecidedAt: now.UTC()}
+
+	for _, result := range results {
+		plate := tools.NormalisePlate(result.OCR.Text)
+		if plate == "" {
+			continue
+		}
+		if d.Plate == "" {
+			d.Plate, d.ReadText, d.Confidence = plate, result.OCR.Text, result.OCR.Confidence
+		}
+		if result.OCR.Confidence < c.minConfidence {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%s was read with confidence %.2f, below the %.2f minimum", plate, result.OCR.Confidence, c.minConfidence))
+			continue
+		}
+
+		grant, err := c.Grant(plate)
+		if errors.Is(err, ErrNotFound) {
+			d.Reasons = append(d.Reasons, fmt.Sprintf("%s is not on the allow-list", plate))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ok, reason := grant.Check(gate, now, c.loc)
+		d.Reasons = append(d.Reasons, reason)
+		if ok {
+			d.Decision = Allow
+			d.Plate, d.ReadText, d.Confidence = plate, result.OCR.Text, result.OCR.Confidence
+			d.Holder = grant.Holder
+			break
+		}
+	}
+	if len(d.Reasons) == 0 {
+		d.Reasons = append(d.Reasons, "no readable plate in the image")
+	}
+	return c.record(d)
+}
+
+// Deny records a deny decision made without reading a plate, such as when
+// ALPR fails, with reason as its only reason.
+func (c *Controller) Deny(gate, reason string, now time.Time) (*Decision, error) {
+	return c.record(Decision{ID: uuid.New().String(), GateID: gate, Decision: Deny, Reasons: []string{reason}, DecidedAt: now.UTC()})
+}
+
+func (c *Controller) record(d Decision) (*Decision, error) {
+	key := decisionPrefix + d.DecidedAt.Format(decisionTimeLayout) + "/" + d.ID
+	if err := c.db.Put(key, d); err != nil {
+		return nil, fmt.Errorf("failed to record access decision: %w", err)
+	}
+	return &d, nil
+}
+
+// Decisions returns the audit log newest first, optionally for one gate.
+func (c *Controller) Decisions(gate string, limit int) ([]Decision, error) {
+	keys := c.db.Keys(decisionPrefix)
+	decisions := []Decision{}
+	for i := len(keys) - 1; i >= 0 && (limit <= 0 || len(decisions) < limit); i-- {
+		var d Decision
+		if _, err := c.db.Get(keys[i], &d); err != nil {
+			return nil, err
+		}
+		if gate != "" && d.GateID != gate {
+			continue
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions, nil
+}
+
+// --- Process-wide controller ---
+
+var defaultController = instance.New(func() (*Controller, error) { return Open("", nil) })
+
+// Configure opens the access store and applies the time zone, confidence
+// threshold and gate webhook from the configuration.
+func Configure(cfg *config.Config) error {
+	loc := time.Local
+	if cfg.AccessTimezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(cfg.AccessTimezone); err != nil {
+			return fmt.Errorf("invalid ACCESSTIMEZONE: %w", err)
+		}
+	}
+	c, err := Open(cfg.AccessStorePath, loc)
+	if err != nil {
+		return err
+	}
+	c.minConfidence = cfg.AccessMinConfidence
+	c.webhookURL = cfg.GateWebhookURL
+	defaultController.Set(c)
+	return nil
+}
+
+// Default returns the configured controller, or an in-memory one if
+// Configure has not run.
+func Default() *Controller {
+	return defaultController.Get()
+}
+
+// DecideAndNotify makes a decision with the default controller, logs it and
+// sends it to the gate controller webhook in the background when one is set.
+func DecideAndNotify(gate string, results []tools.ALPRResult) (*Decision, error) {
+	c := Default()
+	d, err := c.Decide(gate, results, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	c.announce(*d)
+	return d, nil
+}
+
+// DenyAndNotify records a deny with the default controller for a request
+// that could not be decided on its plates, and announces it like any other.
+func DenyAndNotify(gate, reason string) (*Decision, error) {
+	c := Default()
+	d, err := c.Deny(gate, reason, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	c.announce(*d)
+	return d, nil
+}
+
+// announce logs a decision and sends it to the gate webhook when one is set.
+func (c *Controller) announce(decision Decision) {
+	logging.Infof("🚧 ACCESS %s: gate=%q plate=%q (%s)", strings.ToUpper(decision.Decision), decision.GateID, decision.Plate, strings.Join(decision.Reasons, "; "))
+
+	if c.webhookURL != "" {
+		url := c.webhookURL
+		notify.Go(func() {
+			event := map[string]interface{}{"event": "gate.decision", "decision": decision}
+			if err := notify.PostJSON(url, decision.ID, event); err != nil {
+				logging.Errorf("access: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+// Package alerts evaluates DVSA vehicle records against configurable
+// compliance rules (MOT, tax, export, V5C changes) and delivers the resulting
+// alerts to a pluggable sink.
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/notify"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Conditions a rule can test for.
+const (
+	MotExpired      = "mot_expired"
+	MotExpiring     = "mot_expiring"      // MOT expires within Days
+	Untaxed         = "untaxed"           // taxStatus is "Untaxed"
+	SORN            = "sorn"              // taxStatus is "SORN"
+	MarkedForExport = "marked_for_export" // markedForExport is true
+	RecentV5C       = "recent_v5c"        // V5C issued within Days
+)
+
+// Rule is a single compliance check.
+type Rule struct {
+	ID        string `json:"id"`
+	Condition string `json:"condition"`
+	Days      int    `json:"days,omitempty"`
+	Severity  string `json:"severity"` // "info", "warning" or "critical"
+	Disabled  bool   `json:"disabled,omitempty"`
+}
+
+// Alert is raised when a vehicle matches a rule.
+type Alert struct {
+	ID           string    `json:"id"`
+	RuleID       string    `json:"rule_id"`
+	Condition    string    `json:"condition"`
+	Severity     string    `json:"severity"`
+	Registration string    `json:"registration"`
+	Message      string    `json:"message"`
+	RaisedAt     time.Time `json:"raised_at"`
+}
+
+// DefaultRules are used when ALERTRULESPATH is not set.
+var DefaultRules = []Rule{
+	{ID: "mot-expired", Condition: MotExpired, Severity: "critical"},
+	{ID: "mot-expiring", Condition: MotExpiring, Days: 30, Severity: "warning"},
+	{ID: "untaxed", Condition: Untaxed, Severity: "critical"},
+	{ID: "sorn", Condition: SORN, Severity: "critical"},
+	{ID: "marked-for-export", Condition: MarkedForExport, Severity: "warning"},
+	{ID: "recent-v5c", Condition: RecentV5C, Days: 14, Severity: "info"},
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read alert rules: %w", err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules %s: %w", path, err)
+	}
+	for i, r := range rules {
+		if err := validateRule(r); err != nil {
+			return nil, fmt.Errorf("alert rule %d (%s): %w", i+1, r.ID, err)
+		}
+	}
+	return rules, nil
+}
+
+func validateRule(r Rule) error {
+	if r.ID == "" {
+		return fmt.Errorf("id is required")
+	}
+	switch r.Severity {
+	case "info", "warning", "critical":
+	default:
+		return fmt.Errorf("severity must be info, warning or critical, not %q", r.Severity)
+	}
+	switch r.Condition {
+	case MotExpired, Untaxed, SORN, MarkedForExport:
+	case MotExpiring, RecentV5C:
+		if r.Days <= 0 {
+			return fmt.Errorf("condition %s needs a positive days value", r.Condition)
+		}
+	default:
+		return fmt.Errorf("unknown condition %q", r.Condition)
+	}
+	return nil
+}
+
+// Evaluate returns an alert for every enabled rule the vehicle matches.
+func Evaluate(rules []Rule, vehicle *tools.VehicleResponse, now time.Time) []Alert {
+	details := tools.NewVehicleDetails(vehicle, now)
+	raised := []Alert{}
+
+	for _, r := range rules {
+		if r.Disabled {
+			continue
+		}
+		message, matched := match(r, details)
+		if !matched {
+			continue
+		}
+		raised = append(raised, Alert{
+			ID:           uuid.New().String(),
+			RuleID:       r.ID,
+			Condition:    r.Condition,
+			Severity:     r.Severity,
+			Registration: vehicle.RegistrationNumber,
+			Message:      message,
+			RaisedAt:     now.UTC(),
+		})
+	}
+	return raised
+}
+
+func match(r Rule, d *tools.VehicleDetails) (string, bool) {
+	switch r.Condition {
+	case MotExpired:
+		if d.MotExpired {
+			return fmt.Sprintf("MOT expired on %s", d.MotExpiryDate), true
+		}
+		// DVSA reports "Not valid" without a date for some vehicles.
+		if d.DaysUntilMotExpiry == nil && strings.EqualFold(d.MotStatus, "Not valid") {
+			return "MOT is not valid", true
+		}
+	case MotExpiring:
+		if d.DaysUntilMotExpiry != nil && *d.DaysUntilMotExpiry >= 0 && *d.DaysUntilMotExpiry <= r.Days {
+			return fmt.Sprintf("MOT expires in %d days (%s)", *d.DaysUntilMotExpiry, d.MotExpiryDate), true
+		}
+	case Untaxed:
+		if strings.EqualFold(d.TaxStatus, "Untaxed") {
+			return "vehicle is untaxed", true
+		}
+	case SORN:
+		if strings.EqualFold(d.TaxStatus, "SORN") {
+			return "vehicle is declared off the road (SORN)", true
+		}
+	case MarkedForExport:
+		if d.MarkedForExport {
+			return "vehicle is marked for export", true
+		}
+	case RecentV5C:
+		if d.DaysSinceLastV5CIssued != nil && *d.DaysSinceLastV5CIssued <= r.Days {
+			return fmt.Sprintf("V5C issued %d days ago (%s)", *d.DaysSinceLastV5CIssued, d.DateOfLastV5CIssued), true
+		}
+	}
+	return "", false
+}
+
+// --- Process-wide engine ---
+
+var (
+	mu       sync.RWMutex
+	rules         = DefaultRules
+	sink     Sink = LogSink{}
+	cooldown      = 24 * time.Hour
+
+	// delivered records when each registration and rule pair was last sent
+	// to the sink.
+	deliveredMu sync.Mutex
+	delivered   = map[string]time.Time{}
+	prunedAt    time.Time
+)
+
+// Configure loads the rules and sink selected by the configuration.
+func Configure(cfg *config.Config) error {
+	loaded := DefaultRules
+	if cfg.AlertRulesPath != "" {
+		var err error
+		if loaded, err = LoadRules(cfg.AlertRulesPath); err != nil {
+			return err
+		}
+	}
+
+	s, err := NewSink(cfg.AlertSink, cfg.AlertSinkTarget)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	rules = loaded
+	sink = s
+	cooldown = cfg.AlertCooldown
+	return nil
+}
+
+// Check evaluates a vehicle against the configured rules and hands any alerts
+// to the sink in the background. A rule that already alerted the same
+// registration within the cooldown is not delivered again. Every raised alert
+// is returned for inclusion in API responses.
+func Check(vehicle *tools.VehicleResponse) []Alert {
+	if vehicle == nil {
+		return nil
+	}
+
+	mu.RLock()
+	currentRules, currentSink, currentCooldown := rules, sink, cooldown
+	mu.RUnlock()
+
+	now := time.Now()
+	raised := Evaluate(currentRules, vehicle, now)
+	if due := dueForDelivery(raised, now, currentCooldown); len(due) > 0 {
+		notify.Go(func() {
+			if err := currentSink.Deliver(due); err != nil {
+				logging.Errorf("alerts: delivery failed: %v", err)
+			}
+		})
+	}
+	return raised
+}
+
+// dueForDelivery returns the alerts whose registration and rule have not been
+// delivered within the cooldown, and marks them delivered at now.
+func dueForDelivery(raised []Alert, now time.Time, cooldown time.Duration) []Alert {
+	if cooldown <= 0 {
+		return raised
+	}
+	deliveredMu.Lock()
+	defer deliveredMu.Unlock()
+
+	due := make([]Alert, 0, len(raised))
+	for _, a := range raised {
+		key := tools.NormalisePlate(a.Registration) + "/" + a.RuleID
+		if last, ok := delivered[key]; ok && now.Sub(last) < cooldown {
+			continue
+		}
+		delivered[key] = now
+		due = append(due, a)
+	}
+
+	// Forget pairs whose cooldown has passed so the map stays bounded by the
+	// vehicles seen within one cooldown.
+	if now.Sub(prunedAt) >= cooldown {
+		prunedAt = now
+		for key, last := range delivered {
+			if now.Sub(last) >= cooldown {
+				delete(delivered, key)
+			}
+		}
+	}
+	return due
+}
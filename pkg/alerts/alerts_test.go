@@ -0,0 +1,38 @@
+package alerts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCooldownPerRegistrationAndRule(t *testing.T) {
+	t.Cleanup(func() { delivered, prunedAt = map[string]time.Time{}, time.Time{} })
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	mot := Alert{RuleID: "mot-expired", Registration: "AB12CDE"}
+	tax := Alert{RuleID: "untaxed", Registration: "AB12CDE"}
+	other := Alert{RuleID: "mot-expired", Registration: "XY34ZZZ"}
+
+	if due := dueForDelivery([]Alert{mot}, now, time.Hour); len(due) != 1 {
+		t.Fatalf("first alert: %d due, want 1", len(due))
+	}
+	due := dueForDelivery([]Alert{mot, tax, other}, now.Add(time.Minute), time.Hour)
+	if len(due) != 2 || due[0].RuleID != "untaxed" || due[1].Registration != "XY34ZZZ" {
+		t.Errorf("within cooldown: due = %+v, want only the other rule and registration", due)
+	}
+	if due := dueForDelivery([]Alert{mot}, now.Add(time.Hour), time.Hour); len(due) != 1 {
+		t.Errorf("after cooldown: %d due, want 1", len(due))
+	}
+}
+
+func TestLoadRulesRejectsUnknownSeverity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`[{"id": "sorn", "condition": "sorn", "severity": "urgent"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path); err == nil || !strings.Contains(err.Error(), "severity") {
+		t.Errorf("got %v, want a severity error", err)
+	}
+}
@@ -0,0 +1,80 @@
+package alerts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/notify"
+	"sync"
+)
+
+// Sink receives alerts raised by Check.
+type Sink interface {
+	Deliver(alerts []Alert) error
+}
+
+// NewSink builds the sink named by ALERTSINK: "log" (default), "file"
+// (JSON lines appended to target) or "webhook" (POSTed to target).
+func NewSink(kind, target string) (Sink, error) {
+	switch kind {
+	case "", "log":
+		return LogSink{}, nil
+	case "file":
+		if target == "" {
+			return nil, fmt.Errorf("ALERTSINKTARGET must be a file path for the file alert sink")
+		}
+		return &FileSink{Path: target}, nil
+	case "webhook":
+		if target == "" {
+			return nil, fmt.Errorf("ALERTSINKTARGET must be a URL for the webhook alert sink")
+		}
+		return WebhookSink{URL: target}, nil
+	default:
+		return nil, fmt.Errorf("unknown alert sink %q", kind)
+	}
+}
+
+// LogSink writes alerts to the service log.
+type LogSink struct{}
+
+func (LogSink) Deliver(alerts []Alert) error {
+	for _, a := range alerts {
+		logging.Warnf("🚨 ALERT [%s] %s: %s (rule %s)", a.Severity, a.Registration, a.Message, a.RuleID)
+	}
+	return nil
+}
+
+// FileSink appends alerts to a JSON-lines file.
+type FileSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileSink) Deliver(alerts []Alert) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open alert file %s: %w", s.Path, err)
+	}
+	defer file.Close()
+
+	enc := json.NewEncoder(file)
+	for _, a := range alerts {
+		if err := enc.Encode(a); err != nil {
+			return fmt.Errorf("failed to write alert: %w", err)
+		}
+	}
+	return nil
+}
+
+// WebhookSink POSTs each batch of alerts as {"alerts": [...]}.
+type WebhookSink struct {
+	URL string
+}
+
+func (s WebhookSink) Deliver(alerts []Alert) error {
+	return notify.PostJSON(s.URL, alerts[0].ID, map[string]interface{}{"alerts": alerts})
+}
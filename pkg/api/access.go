@@ -0,0 +1,170 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/access"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/parking"
+	"punkplod23/go-agent-ollama-slm/pkg/sightings"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"punkplod23/go-agent-ollama-slm/pkg/watchlist"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+func registerAccessRoutes(admin *mux.Router) {
+	admin.HandleFunc("/access/grants", listGrantsHandler()).Methods("GET")
+	admin.HandleFunc("/access/grants", putGrantHandler()).Methods("POST")
+	admin.HandleFunc("/access/grants/{plate}", getGrantHandler()).Methods("GET")
+	admin.HandleFunc("/access/grants/{plate}", putGrantHandler()).Methods("PUT")
+	admin.HandleFunc("/access/grants/{plate}", deleteGrantHandler()).Methods("DELETE")
+	admin.HandleFunc("/access/decisions", listDecisionsHandler()).Methods("GET")
+}
+
+// accessDecisionHandler runs ALPR on a gate camera image and returns an
+// allow/deny decision with the reasons behind it.
+func accessDecisionHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ImageBase64 string `json:"image_base64"`
+			ImageURL    string `json:"image_url,omitempty"`
+			GateID      string `json:"gate_id"`
+			tools.CaptureMetadata
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.GateID == "" {
+			http.Error(w, "gate_id is required", http.StatusBadRequest)
+			return
+		}
+
+		data, err := loadImage(req.ImageBase64, req.ImageURL, cfg)
+		if err != nil {
+			writeInputError(w, err)
+			return
+		}
+		prepared, err := tools.PrepareImage(data, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		capture, err := tools.ResolveCapture(req.CaptureMetadata, prepared)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// A gate that cannot read the plate stays shut, and the deny is
+		// audited and announced like any other.
+		var decision *access.Decision
+		apiResponse, err := tools.RecognisePreparedImage(prepared, cfg)
+		if err != nil {
+			logging.Errorf("accessDecisionHandler: ALPR failed at gate %s: %v", req.GateID, err)
+			decision, err = access.DenyAndNotify(req.GateID, access.ReasonALPRFailed)
+		} else {
+			parking.Track(sightings.Record(apiResponse.ALPRResults, req.GateID, capture, data))
+			watchlist.CheckAndNotify(apiResponse.ALPRResults)
+			decision, err = access.DecideAndNotify(req.GateID, apiResponse.ALPRResults)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, decision)
+	}
+}
+
+func listGrantsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grants, err := access.Default().Grants()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"grants": grants})
+	}
+}
+
+func getGrantHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		grant, err := access.Default().Grant(mux.Vars(r)["plate"])
+		if err != nil {
+			writeAccessError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, grant)
+	}
+}
+
+// putGrantHandler creates a grant (POST) or replaces the grant for the plate
+// in the path (PUT).
+func putGrantHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req access.Grant
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if plate, ok := mux.Vars(r)["plate"]; ok {
+			req.Plate = plate
+		}
+
+		grant, err := access.Default().PutGrant(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logging.Infof("admin: access grant for %s saved", grant.Plate)
+		writeJSON(w, http.StatusOK, grant)
+	}
+}
+
+func deleteGrantHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plate := mux.Vars(r)["plate"]
+		if err := access.Default().DeleteGrant(plate); err != nil {
+			writeAccessError(w, err)
+			return
+		}
+		logging.Infof("admin: access grant for %s deleted", plate)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listDecisionsHandler returns the decision audit log, newest first,
+// optionally filtered by ?gate= and capped by ?limit= (default 100).
+func listDecisionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		decisions, err := access.Default().Decisions(r.URL.Query().Get("gate"), limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"decisions": decisions})
+	}
+}
+
+func writeAccessError(w http.ResponseWriter, err error) {
+	if errors.Is(err, access.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
@@ -0,0 +1,234 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// adminAuth guards the admin endpoints with a static bearer token.
+// When no token is configured the admin API is switched off entirely.
+func adminAuth(cfg *config.Config) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if cfg.AdminAPIToken == "" {
+				http.Error(w, "admin API is disabled: set ADMINAPITOKEN to enable it", http.StatusForbidden)
+				return
+			}
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.AdminAPIToken)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func registerAdminRoutes(r *mux.Router, cfg *config.Config) {
+	admin := r.PathPrefix("/api/v1/admin").Subrouter()
+	admin.Use(adminAuth(cfg))
+
+	admin.HandleFunc("/owners", listOwnersHandler()).Methods("GET")
+	admin.HandleFunc("/owners", putOwnerHandler()).Methods("POST")
+	admin.HandleFunc("/owners/import", importOwnersHandler()).Methods("POST")
+	admin.HandleFunc("/owners/{registration}", getOwnerHandler()).Methods("GET")
+	admin.HandleFunc("/owners/{registration}", putOwnerHandler()).Methods("PUT")
+	admin.HandleFunc("/owners/{registration}", deleteOwnerHandler()).Methods("DELETE")
+
+	admin.HandleFunc("/cache/dvsa", dvsaCacheStatsHandler()).Methods("GET")
+	admin.HandleFunc("/cache/dvsa", purgeDVSACacheHandler()).Methods("DELETE")
+	admin.HandleFunc("/cache/dvsa/{registration}", purgeDVSACacheHandler()).Methods("DELETE")
+
+	admin.HandleFunc("/egress", egressPolicyHandler()).Methods("GET")
+
+	registerWatchlistRoutes(admin)
+	registerAccessRoutes(admin)
+}
+
+// egressPolicyHandler reports the egress policy in force, including the
+// addresses each allowed host has been pinned to.
+func egressPolicyHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, egress.Current().Summary())
+	}
+}
+
+func listOwnersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		records, err := tools.Owners().List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"owners": records, "count": len(records)})
+	}
+}
+
+func getOwnerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		record, err := tools.Owners().Lookup(mux.Vars(r)["registration"])
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, record)
+	}
+}
+
+// putOwnerHandler creates or replaces a single mapping. On PUT the
+// registration in the path wins over any value in the body.
+func putOwnerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var record tools.OwnerRecord
+		if err := json.NewDecoder(r.Body).Decode(&record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if reg := mux.Vars(r)["registration"]; reg != "" {
+			record.Registration = reg
+		}
+
+		if err := tools.Owners().Put(record); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		saved, err := tools.Owners().Lookup(record.Registration)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		logging.Infof("admin: owner mapping for %s set to %s", saved.Registration, saved.OwnerID)
+		writeJSON(w, http.StatusOK, saved)
+	}
+}
+
+func deleteOwnerHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		reg := mux.Vars(r)["registration"]
+		if err := tools.Owners().Delete(reg); err != nil {
+			writeLookupError(w, err)
+			return
+		}
+		logging.Infof("admin: owner mapping for %s deleted", reg)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// importOwnersHandler bulk-loads mappings from a CSV or JSON document, sent
+// either as a multipart "file" field or as the raw request body.
+func importOwnersHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, format, err := readImportDocument(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer body.Close()
+
+		records, err := tools.ParseOwnerRecords(body, format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		imported, err := tools.ImportOwners(tools.Owners(), records)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		logging.Infof("admin: imported %d owner mappings", imported)
+		writeJSON(w, http.StatusOK, map[string]int{"imported": imported})
+	}
+}
+
+// readImportDocument returns the uploaded document and whether it is "csv" or "json",
+// judged from the file extension or Content-Type.
+func readImportDocument(r *http.Request) (io.ReadCloser, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil { // 32 MB
+			return nil, "", err
+		}
+		file, handler, err := r.FormFile("file")
+		if err != nil {
+			return nil, "", fmt.Errorf("multipart field \"file\" is required")
+		}
+		format := strings.TrimPrefix(strings.ToLower(filepath.Ext(handler.Filename)), ".")
+		if f := r.FormValue("format"); f != "" {
+			format = f
+		}
+		return file, format, nil
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		switch mediaType {
+		case "text/csv":
+			format = "csv"
+		case "application/json":
+			format = "json"
+		default:
+			return nil, "", fmt.Errorf("cannot tell import format from Content-Type %q: pass ?format=csv or ?format=json", mediaType)
+		}
+	}
+	return r.Body, format, nil
+}
+
+func dvsaCacheStatsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := tools.VehicleCache()
+		writeJSON(w, http.StatusOK, map[string]interface{}{"enabled": c != nil, "stats": c.Stats()})
+	}
+}
+
+// purgeDVSACacheHandler evicts one registration, or the whole cache when none is given.
+func purgeDVSACacheHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c := tools.VehicleCache()
+		purged := 0
+		if reg := mux.Vars(r)["registration"]; reg != "" {
+			if c.Delete(tools.NormalisePlate(reg)) {
+				purged = 1
+			}
+		} else {
+			purged = c.Purge()
+		}
+		logging.Infof("admin: purged %d DVSA cache entries", purged)
+		writeJSON(w, http.StatusOK, map[string]int{"purged": purged})
+	}
+}
+
+// writeLookupError maps not-found sentinels to 404 and everything else to 500.
+func writeLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, tools.ErrInvalidRegistration) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, tools.ErrOwnerNotFound) || errors.Is(err, tools.ErrVehicleNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
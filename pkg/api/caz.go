@@ -0,0 +1,51 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/caz"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+)
+
+func listZonesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"zones": caz.Zones()})
+	}
+}
+
+// checkZonesHandler looks up a registration and reports its compliance and
+// daily charge in every configured zone, or only those listed in "zones".
+func checkZonesHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RegistrationID string   `json:"registration_id"`
+			Zones          []string `json:"zones,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.RegistrationID == "" {
+			http.Error(w, "registration_id is required", http.StatusBadRequest)
+			return
+		}
+
+		vehicle, cacheStatus, err := tools.LookupVehicleWithStatus(req.RegistrationID, cfg)
+		w.Header().Set("X-Cache", string(cacheStatus))
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+
+		results, err := caz.Check(vehicle, req.Zones...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"registration_id": vehicle.RegistrationNumber,
+			"zones":           results,
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/clonecheck"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+)
+
+// checkForClone enriches an identification with a cloned plate check when a
+// classifier is configured. It takes the image the identification already
+// prepared. Failures are logged and leave the check out, so the
+// identification itself still succeeds.
+func checkForClone(prepared *tools.PreparedImage, vehicle *tools.VehicleResponse) *clonecheck.Result {
+	if !clonecheck.Enabled() {
+		return nil
+	}
+	result, err := clonecheck.Check(prepared, vehicle)
+	if err != nil {
+		logging.Errorf("clone check: %v", err)
+		return nil
+	}
+	return result
+}
+
+// cloneCheckHandler compares the vehicle in an image with the DVSA record
+// for a given registration.
+func cloneCheckHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !clonecheck.Enabled() {
+			http.Error(w, "cloned plate detection is disabled: set CLONECLASSIFIER to enable it", http.StatusServiceUnavailable)
+			return
+		}
+
+		var req struct {
+			ImageBase64    string `json:"image_base64"`
+			ImageURL       string `json:"image_url,omitempty"`
+			RegistrationID string `json:"registration_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.RegistrationID == "" {
+			http.Error(w, "registration_id is required", http.StatusBadRequest)
+			return
+		}
+
+		data, err := loadImage(req.ImageBase64, req.ImageURL, cfg)
+		if err != nil {
+			writeInputError(w, err)
+			return
+		}
+		prepared, err := tools.PrepareImage(data, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		vehicle, cacheStatus, err := tools.LookupVehicleWithStatus(req.RegistrationID, cfg)
+		w.Header().Set("X-Cache", string(cacheStatus))
+		if err != nil {
+			writeLookupError(w, err)
+			return
+		}
+
+		result, err := clonecheck.Check(prepared, vehicle)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/safefetch"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+)
+
+// fetchError wraps a failed fetch of a caller-supplied URL so handlers can
+// tell it apart from a bad payload.
+type fetchError struct{ err error }
+
+func (e *fetchError) Error() string { return e.err.Error() }
+func (e *fetchError) Unwrap() error { return e.err }
+
+// loadImage returns the image from image_base64 or, when set instead,
+// fetches image_url through the SSRF-safe fetcher, capped at MAXIMAGEBYTES.
+func loadImage(imageBase64, imageURL string, cfg *config.Config) ([]byte, error) {
+	switch {
+	case imageBase64 != "" && imageURL != "":
+		return nil, fmt.Errorf("provide image_base64 or image_url, not both")
+	case imageURL == "":
+		return tools.DecodeBase64Image(imageBase64)
+	}
+
+	resp, err := safefetch.Get(imageURL, cfg.MaxImageBytes)
+	if err != nil {
+		return nil, &fetchError{err}
+	}
+	logging.Debugf("fetched %d byte image from %s", len(resp.Body), resp.FinalURL)
+	return resp.Body, nil
+}
+
+// fetchDocument fetches a text document for ingestion into a knowledge
+// collection and returns its content and a file name for it.
+func fetchDocument(raw string) (string, string, error) {
+	resp, err := safefetch.Get(raw, 0)
+	if err != nil {
+		return "", "", &fetchError{err}
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(resp.ContentType, ";")[0]))
+	if mediaType != "" && !strings.HasPrefix(mediaType, "text/") && mediaType != "application/json" && mediaType != "application/xml" {
+		return "", "", fmt.Errorf("unsupported document type %q: expected text", mediaType)
+	}
+
+	filename := path.Base(strings.TrimSuffix(resp.FinalURL, "/"))
+	if i := strings.IndexAny(filename, "?#"); i >= 0 {
+		filename = filename[:i]
+	}
+	if filename == "" || filename == "." || filename == "/" || strings.Contains(filename, ":") {
+		filename = "document.md"
+	}
+	return string(resp.Body), filename, nil
+}
+
+// writeInputError reports a refused URL as 400, an oversized response as
+// 413, an unreachable URL as 502 and anything else as a bad request.
+func writeInputError(w http.ResponseWriter, err error) {
+	var fe *fetchError
+	switch {
+	case errors.Is(err, safefetch.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, safefetch.ErrTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.As(err, &fe):
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
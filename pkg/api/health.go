@@ -0,0 +1,90 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/ratelimit"
+	"punkplod23/go-agent-ollama-slm/pkg/resilience"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+)
+
+// healthHandler reports liveness plus the circuit breaker state of every
+// upstream. It always answers 200 so an unhealthy upstream does not get this
+// pod restarted; "degraded" means at least one breaker is not closed.
+func healthHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		breakers := resilience.Snapshots()
+		status := "ok"
+		for _, b := range breakers {
+			if b.State != resilience.Closed {
+				status = "degraded"
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"status":       status,
+			"dependencies": breakers,
+		})
+	}
+}
+
+// metricsHandler exposes upstream and cache counters in the Prometheus text format.
+func metricsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var b strings.Builder
+		breakers := resilience.Snapshots()
+
+		gauge := func(name, help string) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		}
+		counter := func(name, help string) {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		}
+
+		gauge("upstream_circuit_state", "Circuit breaker state per upstream (1 for the current state).")
+		for _, s := range breakers {
+			for _, state := range []resilience.State{resilience.Closed, resilience.Open, resilience.HalfOpen} {
+				v := 0
+				if s.State == state {
+					v = 1
+				}
+				fmt.Fprintf(&b, "upstream_circuit_state{upstream=%q,state=%q} %d\n", s.Name, state, v)
+			}
+		}
+
+		series := []struct {
+			name, help string
+			value      func(resilience.Snapshot) int64
+		}{
+			{"upstream_requests_total", "Requests sent upstream, including retries.", func(s resilience.Snapshot) int64 { return s.Requests }},
+			{"upstream_retries_total", "Retried upstream requests.", func(s resilience.Snapshot) int64 { return s.Retries }},
+			{"upstream_failures_total", "Upstream requests that failed with a transient error.", func(s resilience.Snapshot) int64 { return s.Failures }},
+			{"upstream_rejected_total", "Requests rejected by an open circuit breaker.", func(s resilience.Snapshot) int64 { return s.Rejected }},
+			{"upstream_circuit_trips_total", "Times the circuit breaker opened.", func(s resilience.Snapshot) int64 { return s.Trips }},
+		}
+		for _, m := range series {
+			counter(m.name, m.help)
+			for _, s := range breakers {
+				fmt.Fprintf(&b, "%s{upstream=%q} %d\n", m.name, s.Name, m.value(s))
+			}
+		}
+
+		stats := tools.VehicleCache().Stats()
+		gauge("dvsa_cache_entries", "Entries held in the DVSA lookup cache.")
+		fmt.Fprintf(&b, "dvsa_cache_entries %d\n", stats.Entries)
+		counter("dvsa_cache_lookups_total", "DVSA cache lookups by result.")
+		fmt.Fprintf(&b, "dvsa_cache_lookups_total{result=\"hit\"} %d\n", stats.Hits)
+		fmt.Fprintf(&b, "dvsa_cache_lookups_total{result=\"negative_hit\"} %d\n", stats.NegativeHit)
+		fmt.Fprintf(&b, "dvsa_cache_lookups_total{result=\"miss\"} %d\n", stats.Misses)
+
+		counter("egress_denied_total", "Outbound connections refused by the egress policy.")
+		fmt.Fprintf(&b, "egress_denied_total %d\n", egress.Denied())
+
+		counter("rate_limited_total", "API requests refused by the per-client rate limit.")
+		fmt.Fprintf(&b, "rate_limited_total %d\n", ratelimit.Rejected())
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, b.String())
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/ollama"
+	"punkplod23/go-agent-ollama-slm/pkg/webui"
+	"sort"
+)
+
+// catalogueModel describes one model: whether the upstreams offer it,
+// whether chats may ask for it, and what is known about its size.
+type catalogueModel struct {
+	Name          string   `json:"name"`
+	Available     bool     `json:"available"`
+	Allowed       bool     `json:"allowed"`
+	Default       bool     `json:"default,omitempty"`
+	Aliases       []string `json:"aliases,omitempty"`
+	OwnedBy       string   `json:"owned_by,omitempty"`
+	Family        string   `json:"family,omitempty"`
+	ParameterSize string   `json:"parameter_size,omitempty"`
+	Quantization  string   `json:"quantization,omitempty"`
+	ContextLength int      `json:"context_length,omitempty"`
+	SizeBytes     int64    `json:"size_bytes,omitempty"`
+}
+
+type catalogue struct {
+	Default   string              `json:"default"`
+	Models    []*catalogueModel   `json:"models"`
+	Aliases   map[string][]string `json:"aliases"`
+	Fallbacks []string            `json:"fallbacks"`
+	// Errors names each upstream that could not be listed; the rest of the
+	// catalogue is still returned.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// listModelsHandler lists the models Open WebUI offers, and Ollama when
+// OLLAMAURL is set, merged with the configured default, allow-list, aliases
+// and fallbacks. Models that are configured but not offered upstream are
+// listed as unavailable.
+func listModelsHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, buildCatalogue(cfg))
+	}
+}
+
+func buildCatalogue(cfg *config.Config) *catalogue {
+	c := &catalogue{
+		Default:   cfg.OpenWebUIModelName,
+		Aliases:   webui.ModelAliases(cfg),
+		Models:    []*catalogueModel{},
+		Fallbacks: []string{},
+		Errors:    map[string]string{},
+	}
+	byName := map[string]*catalogueModel{}
+	entry := func(name string) *catalogueModel {
+		m, ok := byName[name]
+		if !ok {
+			m = &catalogueModel{Name: name}
+			byName[name] = m
+		}
+		return m
+	}
+
+	upstream, err := webui.ListModels(cfg)
+	if err != nil {
+		logging.Warnf("⚠️ Model catalogue: %v", err)
+		c.Errors["openwebui"] = err.Error()
+	}
+	for _, u := range upstream {
+		m := entry(u.ID)
+		m.Available = true
+		m.OwnedBy = u.OwnedBy
+		if u.Ollama != nil {
+			m.Family = u.Ollama.Details.Family
+			m.ParameterSize = u.Ollama.Details.ParameterSize
+			m.Quantization = u.Ollama.Details.QuantizationLevel
+			m.SizeBytes = u.Ollama.Size
+		}
+		m.ContextLength = u.ContextLength()
+	}
+
+	if cfg.OllamaURL != "" {
+		tags, err := ollama.Tags(cfg)
+		if err != nil {
+			logging.Warnf("⚠️ Model catalogue: %v", err)
+			c.Errors["ollama"] = err.Error()
+		}
+		for _, t := range tags {
+			m := entry(t.Name)
+			m.Available = true
+			if m.OwnedBy == "" {
+				m.OwnedBy = "ollama"
+			}
+			if m.Family == "" {
+				m.Family = t.Details.Family
+			}
+			if m.ParameterSize == "" {
+				m.ParameterSize = t.Details.ParameterSize
+			}
+			if m.Quantization == "" {
+				m.Quantization = t.Details.QuantizationLevel
+			}
+			if m.SizeBytes == 0 {
+				m.SizeBytes = t.Size
+			}
+		}
+	}
+
+	for _, name := range webui.AllowedModels(cfg) {
+		entry(name).Allowed = true
+	}
+	for alias, chain := range c.Aliases {
+		for _, name := range chain {
+			m := entry(name)
+			m.Aliases = append(m.Aliases, alias)
+		}
+	}
+	if c.Default != "" {
+		entry(c.Default).Default = true
+	}
+	c.Fallbacks = append(c.Fallbacks, webui.ModelFallbacks(cfg)...)
+
+	// Ollama reports the trained context window; look it up for the models
+	// chats may use, unless Open WebUI already sets num_ctx for them.
+	if cfg.OllamaURL != "" && c.Errors["ollama"] == "" {
+		for _, m := range byName {
+			if !m.Allowed || !m.Available || m.ContextLength > 0 || m.OwnedBy != "ollama" {
+				continue
+			}
+			if n, err := ollama.ContextLength(cfg, m.Name); err == nil {
+				m.ContextLength = n
+			} else {
+				logging.Warnf("⚠️ Model catalogue: %v", err)
+			}
+		}
+	}
+
+	for _, m := range byName {
+		sort.Strings(m.Aliases)
+		c.Models = append(c.Models, m)
+	}
+	sort.Slice(c.Models, func(i, j int) bool { return c.Models[i].Name < c.Models[j].Name })
+	return c
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/parking"
+	"time"
+)
+
+func listParkingSitesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"sites": parking.Default().Sites()})
+	}
+}
+
+// listParkingSessionsHandler lists sessions, optionally for one ?site= and
+// filtered by ?status=open, closed or all (the default is open).
+func listParkingSessionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := parking.Query{SiteID: r.URL.Query().Get("site")}
+		switch status := r.URL.Query().Get("status"); status {
+		case "", parking.StatusOpen:
+			q.Status = parking.StatusOpen
+		case parking.StatusClosed:
+			q.Status = parking.StatusClosed
+		case "all":
+		default:
+			http.Error(w, fmt.Sprintf("invalid status %q: use open, closed or all", status), http.StatusBadRequest)
+			return
+		}
+
+		sessions, err := parking.Default().Sessions(q, time.Now())
+		if err != nil {
+			writeParkingError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"sessions": sessions})
+	}
+}
+
+// listOverstaysHandler lists sessions that exceeded their site's limit plus
+// grace period. ?open=true restricts it to vehicles that are still parked.
+func listOverstaysHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := parking.Query{SiteID: r.URL.Query().Get("site"), OverstayOnly: true}
+		if r.URL.Query().Get("open") == "true" {
+			q.Status = parking.StatusOpen
+		}
+
+		sessions, err := parking.Default().Sessions(q, time.Now())
+		if err != nil {
+			writeParkingError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"overstays": sessions})
+	}
+}
+
+func writeParkingError(w http.ResponseWriter, err error) {
+	if errors.Is(err, parking.ErrUnknownSite) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
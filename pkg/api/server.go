@@ -2,14 +2,26 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"mime"
 	"net/http"
 	"os"
 	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/alerts"
+	"punkplod23/go-agent-ollama-slm/pkg/caz"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/parking"
+	"punkplod23/go-agent-ollama-slm/pkg/ratelimit"
+	"punkplod23/go-agent-ollama-slm/pkg/sightings"
 	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"punkplod23/go-agent-ollama-slm/pkg/watchlist"
 	"punkplod23/go-agent-ollama-slm/pkg/webui"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -18,21 +30,68 @@ import (
 type CreateChatRequest struct {
 	Prompt      string `json:"prompt"`
 	Content     string `json:"content,omitempty"`
+	ContentURL  string `json:"content_url,omitempty"`
 	KnowledgeID string `json:"knowledge_id,omitempty"`
 	DocumentID  string `json:"document_id,omitempty"`
+	// Model is a model name or alias; empty uses OPENWEBUIMODELNAME.
+	Model string `json:"model,omitempty"`
 }
 
-func StartServer(cfg *config.Config) {
+// NewServer returns the API server on :8080, serving with the configuration
+// in holder. When a reload swaps the configuration, the next request builds
+// a fresh router with it; requests already running finish on the old one.
+// Stop it with Shutdown so requests in flight can finish.
+func NewServer(holder *config.Holder) *http.Server {
+	return &http.Server{Addr: ":8080", Handler: &liveRouter{holder: holder}}
+}
+
+// liveRouter routes each request through a router built for the current configuration.
+type liveRouter struct {
+	holder *config.Holder
+
+	mu     sync.Mutex
+	cfg    *config.Config
+	router http.Handler
+}
+
+func (l *liveRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := l.holder.Get()
+	l.mu.Lock()
+	if cfg != l.cfg {
+		l.router, l.cfg = NewRouter(cfg), cfg
+	}
+	router := l.router
+	l.mu.Unlock()
+	router.ServeHTTP(w, r)
+}
+
+// NewRouter returns the API routes, with their handlers bound to cfg.
+func NewRouter(cfg *config.Config) http.Handler {
 	r := mux.NewRouter()
+	r.Use(ratelimit.Middleware)
 	r.HandleFunc("/api/v1/chat", createChatHandler(cfg)).Methods("POST")
+	r.HandleFunc("/api/v1/models", listModelsHandler(cfg)).Methods("GET")
 	r.HandleFunc("/api/v1/files", addFileHandler(cfg)).Methods("POST")
 	r.HandleFunc("/api/v1/process-base64-image", processBase64ImageHandler(cfg)).Methods("POST")
+	r.HandleFunc("/api/v1/process-image", processImageHandler(cfg)).Methods("POST")
 	r.HandleFunc("/api/v1/vehicle-lookup", vehicleLookupHandler(cfg)).Methods("POST")
+	r.HandleFunc("/api/v1/vehicle-details", vehicleDetailsHandler(cfg)).Methods("POST")
+	r.HandleFunc("/api/v1/identify-vehicle", identifyVehicleHandler(cfg)).Methods("POST")
+	r.HandleFunc("/api/v1/access/decide", accessDecisionHandler(cfg)).Methods("POST")
+	r.HandleFunc("/api/v1/clone-check", cloneCheckHandler(cfg)).Methods("POST")
+	r.HandleFunc("/api/v1/clean-air-zones", listZonesHandler()).Methods("GET")
+	r.HandleFunc("/api/v1/clean-air-zones/check", checkZonesHandler(cfg)).Methods("POST")
+	registerAdminRoutes(r, cfg)
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatalf("could not start server: %v", err)
-	}
+	r.HandleFunc("/api/v1/sightings", listSightingsHandler()).Methods("GET")
+	r.HandleFunc("/api/v1/sightings/latest", latestSightingHandler()).Methods("GET")
+	r.HandleFunc("/api/v1/parking/sites", listParkingSitesHandler()).Methods("GET")
+	r.HandleFunc("/api/v1/parking/sessions", listParkingSessionsHandler()).Methods("GET")
+	r.HandleFunc("/api/v1/parking/overstays", listOverstaysHandler()).Methods("GET")
+
+	r.HandleFunc("/healthz", healthHandler()).Methods("GET")
+	r.HandleFunc("/metrics", metricsHandler()).Methods("GET")
+	return r
 }
 
 func createChatHandler(cfg *config.Config) http.HandlerFunc {
@@ -45,6 +104,25 @@ func createChatHandler(cfg *config.Config) http.HandlerFunc {
 
 		knowledgeID := req.KnowledgeID
 
+		// Check the model before any content is uploaded for it.
+		if _, err := webui.ModelChain(cfg, req.Model); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.ContentURL != "" {
+			if req.Content != "" {
+				http.Error(w, "provide content or content_url, not both", http.StatusBadRequest)
+				return
+			}
+			content, _, err := fetchDocument(req.ContentURL)
+			if err != nil {
+				writeInputError(w, err)
+				return
+			}
+			req.Content = content
+		}
+
 		// If content is provided, add it to the knowledge base
 		if req.Content != "" {
 			if knowledgeID == "" {
@@ -61,17 +139,26 @@ func createChatHandler(cfg *config.Config) http.HandlerFunc {
 			req.DocumentID = DocumentID
 		}
 
-		chatID, err := webui.CreateMainChat(cfg, req.Prompt, req.DocumentID)
+		result, err := webui.CreateChatWithModel(cfg, req.Prompt, req.DocumentID, req.Model)
+		if err != nil && result != nil {
+			// The chat was created but no model took it; say why each failed.
+			writeJSON(w, http.StatusBadGateway, map[string]interface{}{
+				"error":         err.Error(),
+				"chat_id":       result.ChatID,
+				"failed_models": result.Attempts,
+			})
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{
-			"chat_id": chatID,
-			"status":  "chat process initiated",
-		})
+		json.NewEncoder(w).Encode(struct {
+			*webui.ChatResult
+			Status string `json:"status"`
+		}{result, "chat process initiated"})
 	}
 }
 
@@ -82,6 +169,28 @@ func addFileHandler(cfg *config.Config) http.HandlerFunc {
 			return
 		}
 
+		knowledgeID := r.FormValue("knowledgeID")
+		if knowledgeID == "" {
+			http.Error(w, "knowledgeID is required", http.StatusBadRequest)
+			return
+		}
+
+		// A document can be ingested from a URL instead of uploaded.
+		if documentURL := r.FormValue("url"); documentURL != "" {
+			content, filename, err := fetchDocument(documentURL)
+			if err != nil {
+				writeInputError(w, err)
+				return
+			}
+			fileID, err := webui.AddFileToKnowledgeCollection(content, filename, knowledgeID, cfg)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"fileID": fileID})
+			return
+		}
+
 		file, handler, err := r.FormFile("file")
 		if err != nil {
 			http.Error(w, "Error Retrieving the File", http.StatusBadRequest)
@@ -89,12 +198,6 @@ func addFileHandler(cfg *config.Config) http.HandlerFunc {
 		}
 		defer file.Close()
 
-		knowledgeID := r.FormValue("knowledgeID")
-		if knowledgeID == "" {
-			http.Error(w, "knowledgeID is required", http.StatusBadRequest)
-			return
-		}
-
 		// Create a temporary file
 		tempFile, err := os.CreateTemp(cfg.TempDirPath, "upload-*.md")
 		if err != nil {
@@ -129,20 +232,171 @@ func addFileHandler(cfg *config.Config) http.HandlerFunc {
 func processBase64ImageHandler(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var req struct {
-			ImageBase64 string `json:"image_base64"`
+			ImageBase64    string `json:"image_base64"`
+			ImageURL       string `json:"image_url,omitempty"`
+			Annotate       bool   `json:"annotate,omitempty"`
+			AnnotateFormat string `json:"annotate_format,omitempty"`
+			SourceID       string `json:"source_id,omitempty"`
+			tools.CaptureMetadata
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		regID, err := tools.ProcessBase64Image(req.ImageBase64, cfg)
+		data, err := loadImage(req.ImageBase64, req.ImageURL, cfg)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			writeInputError(w, err)
 			return
 		}
 
-		json.NewEncoder(w).Encode(map[string]string{"registration_id": regID})
+		opts := imageOptions{
+			Annotate:       req.Annotate,
+			AnnotateFormat: req.AnnotateFormat,
+			SourceID:       req.SourceID,
+			Capture:        req.CaptureMetadata,
+		}
+		respondWithALPR(w, data, opts, cfg)
+	}
+}
+
+// processImageHandler accepts a binary image either as multipart/form-data
+// (field "image") or as a raw image/* request body.
+func processImageHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := readUploadedImage(w, r, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		capture, err := captureFromForm(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts := imageOptions{
+			Annotate:       r.FormValue("annotate") == "true",
+			AnnotateFormat: r.FormValue("annotate_format"),
+			SourceID:       r.FormValue("source_id"),
+			Capture:        capture,
+		}
+		respondWithALPR(w, data, opts, cfg)
+	}
+}
+
+// imageOptions are the per-request extras shared by the image endpoints.
+type imageOptions struct {
+	Annotate       bool
+	AnnotateFormat string
+	SourceID       string // camera or feed the image came from, recorded on sightings
+	Capture        tools.CaptureMetadata
+}
+
+// captureFromForm reads camera and location metadata from form or query
+// values: camera_id, lane, direction, latitude, longitude and captured_at (RFC 3339).
+func captureFromForm(r *http.Request) (tools.CaptureMetadata, error) {
+	m := tools.CaptureMetadata{
+		CameraID:  r.FormValue("camera_id"),
+		Lane:      r.FormValue("lane"),
+		Direction: r.FormValue("direction"),
+	}
+	for name, dst := range map[string]**float64{"latitude": &m.Latitude, "longitude": &m.Longitude} {
+		if v := r.FormValue(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return m, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*dst = &f
+		}
+	}
+	if v := r.FormValue("captured_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return m, fmt.Errorf("invalid captured_at: %w", err)
+		}
+		m.CapturedAt = &t
+	}
+	return m, nil
+}
+
+// respondWithALPR runs ALPR on an uploaded image and writes the JSON response,
+// adding the annotated image and plate crops when requested.
+func respondWithALPR(w http.ResponseWriter, data []byte, opts imageOptions, cfg *config.Config) {
+	prepared, err := tools.PrepareImage(data, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	capture, err := tools.ResolveCapture(opts.Capture, prepared)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiResponse, err := tools.RecognisePreparedImage(prepared, cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiResponse.Capture = capture
+	parking.Track(sightings.Record(apiResponse.ALPRResults, opts.SourceID, capture, data))
+
+	regID, err := tools.PrimaryRegistration(apiResponse)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"registration_id": regID,
+		"watchlist_hits":  watchlist.CheckAndNotify(apiResponse.ALPRResults),
+	}
+	if capture != nil {
+		response["capture"] = capture
+	}
+
+	if opts.Annotate {
+		annotation, err := tools.AnnotateImage(prepared.Data, apiResponse.ALPRResults, opts.AnnotateFormat)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response["annotated_image"] = annotation.Image
+		response["plate_crops"] = annotation.Crops
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+// readUploadedImage reads the image bytes from a multipart or raw request body,
+// rejecting anything larger than the configured limit.
+func readUploadedImage(w http.ResponseWriter, r *http.Request, cfg *config.Config) ([]byte, error) {
+	// Allow a little headroom for multipart boundaries and headers.
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxImageBytes+(1<<20))
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("missing or invalid Content-Type: %w", err)
+	}
+
+	switch {
+	case mediaType == "multipart/form-data":
+		if err := r.ParseMultipartForm(cfg.MaxImageBytes); err != nil {
+			return nil, err
+		}
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			return nil, fmt.Errorf("multipart field \"image\" is required")
+		}
+		defer file.Close()
+		return io.ReadAll(file)
+	case strings.HasPrefix(mediaType, "image/"), mediaType == "application/octet-stream":
+		return io.ReadAll(r.Body)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Type %q: use multipart/form-data or image/*", mediaType)
 	}
 }
 
@@ -152,20 +406,141 @@ func vehicleLookupHandler(cfg *config.Config) http.HandlerFunc {
 			RegistrationID string `json:"registration_id"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			log.Printf("vehicleLookupHandler: error decoding request body: %v", err)
+			logging.Warnf("vehicleLookupHandler: error decoding request body: %v", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		log.Printf("vehicleLookupHandler: looking up registration ID: %s", req.RegistrationID)
-		ownerID, err := tools.GetOwnerID(req.RegistrationID, cfg)
+		logging.Debugf("vehicleLookupHandler: looking up registration ID: %s", req.RegistrationID)
+		vehicle, cacheStatus, err := tools.LookupVehicleWithStatus(req.RegistrationID, cfg)
+		w.Header().Set("X-Cache", string(cacheStatus))
+		if err != nil {
+			logging.Errorf("vehicleLookupHandler: error looking up vehicle: %v", err)
+			writeLookupError(w, err)
+			return
+		}
+
+		ownerID, err := ownerIDFor(vehicle)
+		if err != nil {
+			logging.Errorf("vehicleLookupHandler: error getting owner ID: %v", err)
+			writeLookupError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"owner_id": ownerID,
+			"alerts":   alerts.Check(vehicle),
+		})
+	}
+}
+
+// ownerIDFor returns the vehicle's owner ID from the owner registry, or nil
+// when the registration has no owner recorded. A missing owner is normal for
+// vehicles outside the fleet, so lookups still answer with the DVSA-derived
+// details rather than 404.
+func ownerIDFor(vehicle *tools.VehicleResponse) (*string, error) {
+	ownerID, err := tools.OwnerIDForVehicle(vehicle)
+	if errors.Is(err, tools.ErrOwnerNotFound) {
+		logging.Debugf("No owner registered for %s", vehicle.RegistrationNumber)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	logging.Debugf("Mapped %s to owner ID %s", vehicle.RegistrationNumber, ownerID)
+	return &ownerID, nil
+}
+
+// identifyVehicleHandler runs ALPR on an image and verifies the read against DVSA,
+// falling back to OCR-confusion candidates when the plate as read is unknown.
+func identifyVehicleHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ImageBase64 string `json:"image_base64"`
+			ImageURL    string `json:"image_url,omitempty"`
+			SourceID    string `json:"source_id,omitempty"`
+			tools.CaptureMetadata
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, err := loadImage(req.ImageBase64, req.ImageURL, cfg)
+		if err != nil {
+			writeInputError(w, err)
+			return
+		}
+
+		prepared, err := tools.PrepareImage(data, cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		capture, err := tools.ResolveCapture(req.CaptureMetadata, prepared)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		apiResponse, err := tools.RecognisePreparedImage(prepared, cfg)
 		if err != nil {
-			log.Printf("vehicleLookupHandler: error getting owner ID: %v", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		apiResponse.Capture = capture
+		parking.Track(sightings.Record(apiResponse.ALPRResults, req.SourceID, capture, data))
 
-		log.Printf("vehicleLookupHandler: successfully retrieved owner ID: %s", ownerID)
-		json.NewEncoder(w).Encode(map[string]string{"owner_id": ownerID})
+		ocrText, err := tools.PrimaryRegistration(apiResponse)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		hits := watchlist.CheckAndNotify(apiResponse.ALPRResults)
+
+		verification, err := tools.VerifyRegistration(ocrText, cfg)
+		if err != nil {
+			logging.Errorf("identifyVehicleHandler: verification of %s failed: %v", ocrText, err)
+			status := http.StatusInternalServerError
+			if errors.Is(err, tools.ErrVehicleNotFound) {
+				status = http.StatusNotFound
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":          err.Error(),
+				"verification":   verification,
+				"watchlist_hits": hits,
+				"capture":        capture,
+			})
+			return
+		}
+
+		ownerID, err := ownerIDFor(verification.Vehicle)
+		if err != nil {
+			logging.Errorf("identifyVehicleHandler: %v", err)
+			writeLookupError(w, err)
+			return
+		}
+
+		response := map[string]interface{}{
+			"registration_id": verification.RegistrationID,
+			"owner_id":        ownerID,
+			"verification":    verification,
+			"alerts":          alerts.Check(verification.Vehicle),
+			"watchlist_hits":  hits,
+			"capture":         capture,
+		}
+		if zones, err := caz.Check(verification.Vehicle); err == nil {
+			response["clean_air_zones"] = zones
+		}
+		if cloneCheck := checkForClone(prepared, verification.Vehicle); cloneCheck != nil {
+			response["clone_check"] = cloneCheck
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
 	}
 }
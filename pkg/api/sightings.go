@@ -0,0 +1,78 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/sightings"
+	"strconv"
+	"time"
+)
+
+// listSightingsHandler queries the sighting history. Supported query
+// parameters: plate, source_id, camera_id, from and to (RFC 3339), limit and offset.
+func listSightingsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q, err := parseSightingQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		page, err := sightings.Default().Find(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// latestSightingHandler answers "when did we last see this vehicle?".
+func latestSightingHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plate := r.URL.Query().Get("plate")
+		if plate == "" {
+			http.Error(w, "plate is required", http.StatusBadRequest)
+			return
+		}
+
+		sighting, err := sightings.Default().Latest(plate)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sighting == nil {
+			http.Error(w, fmt.Sprintf("no sightings of %s", plate), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, sighting)
+	}
+}
+
+func parseSightingQuery(r *http.Request) (sightings.Query, error) {
+	v := r.URL.Query()
+	q := sightings.Query{Plate: v.Get("plate"), SourceID: v.Get("source_id"), CameraID: v.Get("camera_id")}
+
+	var err error
+	if s := v.Get("from"); s != "" {
+		if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+	if s := v.Get("to"); s != "" {
+		if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+	if s := v.Get("limit"); s != "" {
+		if q.Limit, err = strconv.Atoi(s); err != nil || q.Limit < 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+	}
+	if s := v.Get("offset"); s != "" {
+		if q.Offset, err = strconv.Atoi(s); err != nil || q.Offset < 0 {
+			return q, fmt.Errorf("invalid offset %q", s)
+		}
+	}
+	return q, nil
+}
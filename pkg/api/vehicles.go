@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/alerts"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"time"
+)
+
+// vehicleDetailsHandler returns the complete DVSA record for a registration
+// together with derived fields such as vehicle age and days until MOT expiry.
+func vehicleDetailsHandler(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			RegistrationID string `json:"registration_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		registrationID := tools.NormalisePlate(req.RegistrationID)
+		if registrationID == "" {
+			http.Error(w, "registration_id is required", http.StatusBadRequest)
+			return
+		}
+
+		vehicle, cacheStatus, err := tools.LookupVehicleWithStatus(registrationID, cfg)
+		w.Header().Set("X-Cache", string(cacheStatus))
+		if err != nil {
+			logging.Warnf("vehicleDetailsHandler: lookup of %s failed: %v", registrationID, err)
+			if errors.Is(err, tools.ErrVehicleNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			*tools.VehicleDetails
+			Alerts []alerts.Alert `json:"alerts"`
+		}{tools.NewVehicleDetails(vehicle, time.Now()), alerts.Check(vehicle)})
+	}
+}
@@ -0,0 +1,146 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/watchlist"
+
+	"github.com/gorilla/mux"
+)
+
+func registerWatchlistRoutes(admin *mux.Router) {
+	admin.HandleFunc("/watchlists", listWatchlistsHandler()).Methods("GET")
+	admin.HandleFunc("/watchlists", createWatchlistHandler()).Methods("POST")
+	admin.HandleFunc("/watchlists/{id}", getWatchlistHandler()).Methods("GET")
+	admin.HandleFunc("/watchlists/{id}", deleteWatchlistHandler()).Methods("DELETE")
+	admin.HandleFunc("/watchlists/{id}/entries", addWatchlistEntryHandler()).Methods("POST")
+	admin.HandleFunc("/watchlists/{id}/entries/{plate}", removeWatchlistEntryHandler()).Methods("DELETE")
+	admin.HandleFunc("/watchlists/{id}/import", importWatchlistHandler()).Methods("POST")
+}
+
+func listWatchlistsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lists, err := watchlist.Default().Lists()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"watchlists": lists})
+	}
+}
+
+func createWatchlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req watchlist.Watchlist
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list, err := watchlist.Default().CreateList(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logging.Infof("admin: watchlist %q created (%s)", list.Name, list.ID)
+		writeJSON(w, http.StatusCreated, list)
+	}
+}
+
+// getWatchlistHandler returns a watchlist together with its entries.
+func getWatchlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		list, err := watchlist.Default().GetList(id)
+		if err != nil {
+			writeWatchlistError(w, err)
+			return
+		}
+		entries, err := watchlist.Default().Entries(id)
+		if err != nil {
+			writeWatchlistError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"watchlist": list, "entries": entries})
+	}
+}
+
+func deleteWatchlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if err := watchlist.Default().DeleteList(id); err != nil {
+			writeWatchlistError(w, err)
+			return
+		}
+		logging.Infof("admin: watchlist %s deleted", id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func addWatchlistEntryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req watchlist.Entry
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		entry, err := watchlist.Default().AddEntry(mux.Vars(r)["id"], req)
+		if err != nil {
+			writeWatchlistError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusCreated, entry)
+	}
+}
+
+func removeWatchlistEntryHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		if err := watchlist.Default().RemoveEntry(vars["id"], vars["plate"]); err != nil {
+			writeWatchlistError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// importWatchlistHandler adds entries from a CSV (plate,reason,expires_at)
+// sent as a multipart "file" field or as a text/csv body.
+func importWatchlistHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		if _, err := watchlist.Default().GetList(id); err != nil {
+			writeWatchlistError(w, err)
+			return
+		}
+
+		body := r.Body
+		if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "multipart/form-data" {
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, "multipart field \"file\" is required", http.StatusBadRequest)
+				return
+			}
+			defer file.Close()
+			body = file
+		}
+
+		imported, err := watchlist.Default().ImportCSV(id, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logging.Infof("admin: imported %d entries into watchlist %s", imported, id)
+		writeJSON(w, http.StatusOK, map[string]int{"imported": imported})
+	}
+}
+
+func writeWatchlistError(w http.ResponseWriter, err error) {
+	if errors.Is(err, watchlist.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+}
@@ -0,0 +1,292 @@
+// Package cache provides a size-bounded LRU cache with per-entry expiry and
+// negative caching, optionally snapshotted to disk so it survives restarts.
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Status describes how a lookup was answered. It is reported to API callers
+// in the X-Cache response header.
+type Status string
+
+const (
+	Miss        Status = "MISS"
+	Hit         Status = "HIT"
+	NegativeHit Status = "NEGATIVE-HIT" // a cached "does not exist" answer
+	Bypass      Status = "BYPASS"       // caching is disabled
+)
+
+type entry[V any] struct {
+	Key      string    `json:"key"`
+	Value    V         `json:"value"`
+	Negative bool      `json:"negative,omitempty"`
+	Expires  time.Time `json:"expires"`
+}
+
+// Stats is a point-in-time view of cache usage.
+type Stats struct {
+	Entries     int   `json:"entries"`
+	Capacity    int   `json:"capacity"`
+	Hits        int64 `json:"hits"`
+	NegativeHit int64 `json:"negative_hits"`
+	Misses      int64 `json:"misses"`
+}
+
+// Cache is an LRU cache of V keyed by string. It is safe for concurrent use.
+type Cache[V any] struct {
+	mu          sync.Mutex
+	capacity    int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	ll          *list.List // front is most recently used
+	items       map[string]*list.Element
+	dirty       bool
+
+	hits, negativeHits, misses int64
+
+	now func() time.Time
+}
+
+// New returns a cache holding at most capacity entries. Positive entries live
+// for ttl and negative entries for negativeTTL (0 disables negative caching).
+func New[V any](capacity int, ttl, negativeTTL time.Duration) *Cache[V] {
+	return &Cache[V]{
+		capacity:    capacity,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		ll:          list.New(),
+		items:       make(map[string]*list.Element),
+		now:         time.Now,
+	}
+}
+
+// Get returns the cached value for key. Expired entries are evicted and
+// reported as a miss.
+func (c *Cache[V]) Get(key string) (V, Status) {
+	var zero V
+	if c == nil {
+		return zero, Bypass
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return zero, Miss
+	}
+	e := el.Value.(*entry[V])
+	if c.now().After(e.Expires) {
+		c.removeElement(el)
+		c.misses++
+		return zero, Miss
+	}
+
+	c.ll.MoveToFront(el)
+	if e.Negative {
+		c.negativeHits++
+		return zero, NegativeHit
+	}
+	c.hits++
+	return e.Value, Hit
+}
+
+// Set caches value under key for the positive TTL.
+func (c *Cache[V]) Set(key string, value V) {
+	if c == nil {
+		return
+	}
+	c.put(&entry[V]{Key: key, Value: value, Expires: c.now().Add(c.ttl)})
+}
+
+// SetNegative records that key does not exist upstream.
+func (c *Cache[V]) SetNegative(key string) {
+	if c == nil || c.negativeTTL <= 0 {
+		return
+	}
+	c.put(&entry[V]{Key: key, Negative: true, Expires: c.now().Add(c.negativeTTL)})
+}
+
+func (c *Cache[V]) put(e *entry[V]) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[e.Key]; ok {
+		el.Value = e
+		c.ll.MoveToFront(el)
+	} else {
+		c.items[e.Key] = c.ll.PushFront(e)
+	}
+	for c.capacity > 0 && c.ll.Len() > c.capacity {
+		c.removeElement(c.ll.Back())
+	}
+	c.dirty = true
+}
+
+// Delete evicts key and reports whether it was present.
+func (c *Cache[V]) Delete(key string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if ok {
+		c.removeElement(el)
+	}
+	return ok
+}
+
+// Purge evicts every entry and returns how many were removed.
+func (c *Cache[V]) Purge() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.ll.Len()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.dirty = true
+	return n
+}
+
+// Stats returns current usage counters.
+func (c *Cache[V]) Stats() Stats {
+	if c == nil {
+		return Stats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return Stats{
+		Entries:     c.ll.Len(),
+		Capacity:    c.capacity,
+		Hits:        c.hits,
+		NegativeHit: c.negativeHits,
+		Misses:      c.misses,
+	}
+}
+
+func (c *Cache[V]) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry[V]).Key)
+	c.dirty = true
+}
+
+// --- Persistence ---
+
+// Save writes unexpired entries to path, most recently used first.
+// It is a no-op when nothing has changed since the last save.
+func (c *Cache[V]) Save(path string) error {
+	if c == nil || path == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	now := c.now()
+	entries := make([]*entry[V], 0, c.ll.Len())
+	for el := c.ll.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry[V])
+		if now.Before(e.Expires) {
+			entries = append(entries, e)
+		}
+	}
+	// Cleared before writing so changes made meanwhile mark it dirty again;
+	// restored if the snapshot does not reach disk.
+	c.dirty = false
+	c.mu.Unlock()
+
+	if err := writeSnapshot(path, entries); err != nil {
+		c.mu.Lock()
+		c.dirty = true
+		c.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func writeSnapshot[V any](path string, entries []*entry[V]) error {
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache snapshot: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write cache snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace cache snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load restores entries written by Save, skipping any that have expired.
+// A missing file is not an error.
+func (c *Cache[V]) Load(path string) error {
+	if c == nil || path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read cache snapshot: %w", err)
+	}
+
+	var entries []*entry[V]
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to decode cache snapshot %s: %w", path, err)
+	}
+
+	now := c.now()
+	// Insert least recently used first so the LRU order is preserved.
+	for i := len(entries) - 1; i >= 0; i-- {
+		if now.Before(entries[i].Expires) {
+			c.put(entries[i])
+		}
+	}
+	c.mu.Lock()
+	c.dirty = false
+	c.mu.Unlock()
+	return nil
+}
+
+// PersistEvery saves the cache to path on every tick until stop is closed,
+// then saves one final time.
+func (c *Cache[V]) PersistEvery(path string, interval time.Duration, stop <-chan struct{}, onError func(error)) {
+	if c == nil || path == "" || interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Save(path); err != nil && onError != nil {
+				onError(err)
+			}
+		case <-stop:
+			if err := c.Save(path); err != nil && onError != nil {
+				onError(err)
+			}
+			return
+		}
+	}
+}
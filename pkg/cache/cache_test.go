@@ -0,0 +1,39 @@
+package cache_test
+
+import (
+	"os"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/pkg/cache"
+	"testing"
+	"time"
+)
+
+func TestFailedSaveIsRetried(t *testing.T) {
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := cache.New[string](10, time.Hour, time.Minute)
+	c.Set("AB12CDE", "FORD")
+
+	// A file where the directory should be makes the save fail.
+	path := filepath.Join(blocker, "cache.json")
+	if err := c.Save(path); err == nil {
+		t.Fatal("save under a file succeeded")
+	}
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := cache.New[string](10, time.Hour, time.Minute)
+	if err := restored.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := restored.Get("AB12CDE"); v != "FORD" {
+		t.Errorf("entry changed before a failed save was not written on the retry: got %q", v)
+	}
+}
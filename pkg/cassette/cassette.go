@@ -0,0 +1,503 @@
+// Package cassette records upstream HTTP exchanges to files and replays
+// them later, so the chat flow and tool parsing can be tested from real
+// Open WebUI, ALPR and DVSA sessions without those services.
+//
+// Requests are matched on method, path, query and a normalised body: IDs,
+// timestamps and multipart boundaries that change between runs are masked
+// before comparing. Credentials are redacted before anything is written.
+package cassette
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Modes.
+const (
+	ModeOff    = ""
+	ModeRecord = "record"
+	ModeReplay = "replay"
+)
+
+// Redacted replaces secrets in recorded requests and responses.
+const Redacted = "REDACTED"
+
+// Request is a recorded request.
+type Request struct {
+	Method     string              `json:"method"`
+	Path       string              `json:"path"`
+	Query      string              `json:"query,omitempty"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 string              `json:"body_base64,omitempty"` // used instead of Body for binary content
+}
+
+// Response is a recorded response.
+type Response struct {
+	Status     int                 `json:"status"`
+	Header     map[string][]string `json:"header,omitempty"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 string              `json:"body_base64,omitempty"`
+}
+
+// Interaction is one recorded exchange.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Cassette is a file of interactions.
+type Cassette struct {
+	Path         string        `json:"-"`
+	Interactions []Interaction `json:"interactions"`
+
+	mu   sync.Mutex
+	used []bool
+}
+
+// Load reads a cassette. A missing file gives an empty cassette.
+func Load(path string) (*Cassette, error) {
+	c := &Cassette{Path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	c.used = make([]bool, len(c.Interactions))
+	return c, nil
+}
+
+// Save writes the cassette atomically.
+func (c *Cassette) Save() error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // keep bodies readable in diffs
+	enc.SetIndent("", "  ")
+	c.mu.Lock()
+	err := enc.Encode(c)
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+	data := buf.Bytes()
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	tmp := c.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	if err := os.Rename(tmp, c.Path); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// add appends an interaction.
+func (c *Cassette) add(i Interaction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Interactions = append(c.Interactions, i)
+	c.used = append(c.used, true)
+}
+
+// find returns the first unused interaction matching the request, so
+// repeated identical requests (such as polling) replay in recorded order.
+// Once every match has been used, the last one is replayed again.
+func (c *Cassette) find(method, path, query, body string) (*Interaction, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	path, query = normalisePath(path), normaliseQuery(query)
+	last := -1
+	for i := range c.Interactions {
+		rec := &c.Interactions[i].Request
+		if rec.Method != method || normalisePath(rec.Path) != path || normaliseQuery(rec.Query) != query ||
+			normaliseBody(recordedBody(rec)) != body {
+			continue
+		}
+		if !c.used[i] {
+			c.used[i] = true
+			return &c.Interactions[i], true
+		}
+		last = i
+	}
+	if last >= 0 {
+		return &c.Interactions[last], true
+	}
+	return nil, false
+}
+
+func recordedBody(r *Request) string {
+	if r.BodyBase64 != "" {
+		data, _ := base64.StdEncoding.DecodeString(r.BodyBase64)
+		return string(data)
+	}
+	return r.Body
+}
+
+// --- Normalisation ---
+
+var (
+	uuidPattern      = regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`)
+	boundaryPattern  = regexp.MustCompile(`-{2,}[0-9a-fA-F]{20,}`)
+	dateTimePattern  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}`)
+	volatileJSONKeys = map[string]bool{"timestamp": true, "created_at": true, "updated_at": true}
+)
+
+func normalisePath(p string) string {
+	return uuidPattern.ReplaceAllString(p, "<id>")
+}
+
+// normaliseQuery redacts credentials, sorts the parameters and masks IDs, so
+// a live query compares equal to the one recorded.
+func normaliseQuery(raw string) string {
+	q, err := url.ParseQuery(raw)
+	if err != nil {
+		return uuidPattern.ReplaceAllString(raw, "<id>")
+	}
+	return uuidPattern.ReplaceAllString(redactQuery(q), "<id>")
+}
+
+// normaliseBody masks what changes between runs. JSON is re-encoded with
+// sorted keys; anything else has IDs and multipart boundaries masked.
+func normaliseBody(body string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err == nil {
+		data, _ := json.Marshal(normaliseJSON(v))
+		return string(data)
+	}
+	body = boundaryPattern.ReplaceAllString(body, "--<boundary>")
+	return uuidPattern.ReplaceAllString(body, "<id>")
+}
+
+func normaliseJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		// Keys that mask to the same name, such as messages keyed by ID,
+		// are kept as a sorted list so no value is lost at random.
+		grouped := map[string][]interface{}{}
+		for k, val := range t {
+			nk := uuidPattern.ReplaceAllString(k, "<id>")
+			switch {
+			case secretName(k):
+				val = Redacted
+			case volatileJSONKeys[strings.ToLower(k)]:
+				val = "<volatile>"
+			default:
+				val = normaliseJSON(val)
+			}
+			grouped[nk] = append(grouped[nk], val)
+		}
+		out := make(map[string]interface{}, len(grouped))
+		for nk, vals := range grouped {
+			if len(vals) == 1 {
+				out[nk] = vals[0]
+				continue
+			}
+			sort.Slice(vals, func(i, j int) bool {
+				a, _ := json.Marshal(vals[i])
+				b, _ := json.Marshal(vals[j])
+				return string(a) < string(b)
+			})
+			out[nk] = vals
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(t))
+		for i, val := range t {
+			out[i] = normaliseJSON(val)
+		}
+		return out
+	case string:
+		if dateTimePattern.MatchString(t) {
+			return "<time>"
+		}
+		return uuidPattern.ReplaceAllString(t, "<id>")
+	}
+	return v
+}
+
+// --- Redaction ---
+
+// secretName reports whether a header, query parameter or JSON key holds a credential.
+func secretName(name string) bool {
+	n := strings.ToLower(name)
+	for _, s := range []string{"authorization", "cookie", "api-key", "api_key", "apikey"} {
+		if strings.Contains(n, s) {
+			return true
+		}
+	}
+	// Suffixes rather than substrings, so fields such as max_tokens survive.
+	return strings.HasSuffix(n, "token") || strings.HasSuffix(n, "secret") || strings.HasSuffix(n, "password")
+}
+
+func redactHeader(h http.Header) map[string][]string {
+	if len(h) == 0 {
+		return nil
+	}
+	out := map[string][]string{}
+	for k, vals := range h {
+		if secretName(k) {
+			out[k] = []string{Redacted}
+			continue
+		}
+		out[k] = append([]string(nil), vals...)
+	}
+	return out
+}
+
+func redactQuery(q url.Values) string {
+	for k := range q {
+		if secretName(k) {
+			q.Set(k, Redacted)
+		}
+	}
+	return q.Encode()
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if secretName(k) {
+				t[k] = Redacted
+				continue
+			}
+			t[k] = redactJSON(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redactJSON(val)
+		}
+	}
+	return v
+}
+
+// encodeBody stores a body as text when it is valid UTF-8 and base64
+// otherwise. JSON bodies have secret fields redacted.
+func encodeBody(body []byte) (text, b64 string) {
+	if len(body) == 0 {
+		return "", ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if redacted, err := json.Marshal(redactJSON(v)); err == nil {
+			return string(redacted), ""
+		}
+	}
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(body)
+}
+
+// --- Transport ---
+
+// Transport records exchanges through Next, or replays them without
+// making any request.
+type Transport struct {
+	Mode     string
+	Cassette *Cassette
+	Next     http.RoundTripper
+}
+
+// New loads the cassette at path and returns a transport in the given mode.
+func New(path, mode string, next http.RoundTripper) (*Transport, error) {
+	c, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Transport{Mode: mode, Cassette: c, Next: next}, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, fmt.Errorf("cassette: failed to read request body: %w", err)
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	switch t.Mode {
+	case ModeReplay:
+		return t.replay(req, body)
+	case ModeRecord:
+		return t.record(req, body)
+	}
+	return t.Next.RoundTrip(req)
+}
+
+func (t *Transport) replay(req *http.Request, body []byte) (*http.Response, error) {
+	i, ok := t.Cassette.find(req.Method, req.URL.Path, req.URL.RawQuery, normaliseBody(string(body)))
+	if !ok {
+		return nil, fmt.Errorf("cassette %s: no recorded interaction for %s %s", t.Cassette.Path, req.Method, req.URL.RequestURI())
+	}
+
+	respBody := []byte(i.Response.Body)
+	if i.Response.BodyBase64 != "" {
+		respBody, _ = base64.StdEncoding.DecodeString(i.Response.BodyBase64)
+	}
+	header := http.Header{}
+	for k, vals := range i.Response.Header {
+		header[k] = append([]string(nil), vals...)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.Response.Status, http.StatusText(i.Response.Status)),
+		StatusCode:    i.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+func (t *Transport) record(req *http.Request, body []byte) (*http.Response, error) {
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cassette: failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	rec := Interaction{
+		Request: Request{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Query:  redactQuery(req.URL.Query()),
+			Header: redactHeader(req.Header),
+		},
+		Response: Response{Status: resp.StatusCode, Header: redactHeader(resp.Header)},
+	}
+	// The boundary is random; mask it as normaliseBody does so the
+	// recorded body is stable.
+	if mediaType, params, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err == nil && strings.HasPrefix(mediaType, "multipart/") {
+		body = bytes.ReplaceAll(body, []byte("--"+params["boundary"]), []byte("--<boundary>"))
+	}
+	rec.Request.Body, rec.Request.BodyBase64 = encodeBody(body)
+	rec.Response.Body, rec.Response.BodyBase64 = encodeBody(respBody)
+
+	t.Cassette.add(rec)
+	if err := t.Cassette.Save(); err != nil {
+		logging.Errorf("cassette: %v", err)
+	}
+	return resp, nil
+}
+
+// --- Process-wide recorder ---
+
+var (
+	mu        sync.Mutex
+	mode      atomic.Value // string
+	dir       string
+	cassettes = map[string]*Cassette{}
+)
+
+func init() { mode.Store(ModeOff) }
+
+// Configure sets the mode and directory from CASSETTEMODE and CASSETTEDIR.
+func Configure(cfg *config.Config) error {
+	m := strings.ToLower(cfg.CassetteMode)
+	switch m {
+	case ModeOff, ModeRecord, ModeReplay:
+	default:
+		return fmt.Errorf("unknown CASSETTEMODE %q: use record or replay", cfg.CassetteMode)
+	}
+	// Unchanged on reload: keep the loaded cassettes and anything recorded so far.
+	mu.Lock()
+	unchanged := mode.Load() == m && dir == cfg.CassetteDir
+	mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	return Use(m, cfg.CassetteDir)
+}
+
+// Use switches mode and cassette directory, dropping loaded cassettes.
+func Use(m, directory string) error {
+	if m != ModeOff && directory == "" {
+		return fmt.Errorf("a cassette directory is required to %s", m)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	dir, cassettes = directory, map[string]*Cassette{}
+	mode.Store(m)
+	if m != ModeOff {
+		logging.Infof("📼 Cassettes: %s in %s", m, directory)
+	}
+	return nil
+}
+
+// cassetteFor returns the process-wide cassette for an upstream, loading it
+// on first use. Recording starts a fresh cassette rather than appending.
+func cassetteFor(name, m string) (*Cassette, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := cassettes[name]; ok {
+		return c, nil
+	}
+	path := filepath.Join(dir, name+".json")
+	c := &Cassette{Path: path}
+	if m == ModeReplay {
+		var err error
+		if c, err = Load(path); err != nil {
+			return nil, err
+		}
+	}
+	cassettes[name] = c
+	return c, nil
+}
+
+type wrapped struct {
+	name string
+	next http.RoundTripper
+}
+
+// Wrap returns a transport that passes through to next while cassettes are
+// off and otherwise records to or replays from <dir>/<name>.json. The mode
+// is read on every request, so clients built before Configure still follow it.
+func Wrap(name string, next http.RoundTripper) http.RoundTripper {
+	return &wrapped{name: name, next: next}
+}
+
+func (w *wrapped) RoundTrip(req *http.Request) (*http.Response, error) {
+	m := mode.Load().(string)
+	if m == ModeOff {
+		return w.next.RoundTrip(req)
+	}
+	c, err := cassetteFor(w.name, m)
+	if err != nil {
+		return nil, err
+	}
+	return (&Transport{Mode: m, Cassette: c, Next: w.next}).RoundTrip(req)
+}
@@ -0,0 +1,55 @@
+package cassette_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"testing"
+)
+
+func get(t *testing.T, rt http.RoundTripper, url string) (string, error) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), nil
+}
+
+func TestReplayMatchesQuery(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Query().Get("plate"))
+	}))
+	defer srv.Close()
+	path := filepath.Join(t.TempDir(), "lookup.json")
+
+	recorder, err := cassette.New(path, cassette.ModeRecord, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"?plate=AB12CDE&api_key=one", "?plate=XY34ZZZ&api_key=one"} {
+		if _, err := get(t, recorder, srv.URL+"/vehicles"+query); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := recorder.Cassette.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	player, err := cassette.New(path, cassette.ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Parameter order and credentials do not affect the match.
+	if body, err := get(t, player, "http://upstream.test/vehicles?api_key=two&plate=XY34ZZZ"); err != nil || body != "XY34ZZZ" {
+		t.Errorf("replayed %q, %v, want the XY34ZZZ response", body, err)
+	}
+	if _, err := get(t, player, "http://upstream.test/vehicles?plate=ZZ99ZZZ&api_key=two"); err == nil {
+		t.Error("a query that was never recorded was replayed")
+	}
+}
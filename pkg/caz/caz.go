@@ -0,0 +1,246 @@
+// Package caz works out whether a vehicle meets the emission standards of
+// configurable clean air and low emission zones, and the daily charge that
+// applies if it does not.
+package caz
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Standard is the minimum a vehicle of the listed fuel types must meet.
+// MinYear is used when DVSA does not report a Euro status, as a proxy for
+// the standard the vehicle was built to.
+type Standard struct {
+	FuelTypes     []string `json:"fuel_types"`
+	MinEuroStatus int      `json:"min_euro_status,omitempty"`
+	MinYear       int      `json:"min_year,omitempty"`
+	MaxCO2        int      `json:"max_co2,omitempty"` // g/km; 0 means no limit
+}
+
+// Zone is a clean air zone. Charges is keyed by type approval category
+// (e.g. "M1" cars, "N1" vans, "N3" HGVs) with "default" for the rest; a
+// vehicle whose category is not charged is outside the zone's scope.
+type Zone struct {
+	ID               string             `json:"id"`
+	Name             string             `json:"name"`
+	Currency         string             `json:"currency,omitempty"`
+	Charges          map[string]float64 `json:"charges"`
+	ExemptFuelTypes  []string           `json:"exempt_fuel_types,omitempty"`
+	HistoricAgeYears int                `json:"historic_age_years,omitempty"` // vehicles at least this old are exempt
+	Standards        []Standard         `json:"standards"`
+}
+
+// Result is a vehicle's status in one zone.
+type Result struct {
+	ZoneID      string  `json:"zone_id"`
+	ZoneName    string  `json:"zone_name"`
+	Compliant   bool    `json:"compliant"`
+	Exempt      bool    `json:"exempt,omitempty"`
+	DailyCharge float64 `json:"daily_charge"`
+	Currency    string  `json:"currency"`
+	Reason      string  `json:"reason"`
+}
+
+// DefaultZones are used when CAZZONESPATH is not set: a generic class D zone
+// with the standards common to the UK schemes.
+var DefaultZones = []Zone{
+	{
+		ID:               "uk-caz-d",
+		Name:             "UK Clean Air Zone (class D)",
+		Currency:         "GBP",
+		Charges:          map[string]float64{"default": 8, "M2": 50, "M3": 50, "N2": 50, "N3": 50},
+		ExemptFuelTypes:  []string{"ELECTRICITY", "HYDROGEN", "FUEL CELLS"},
+		HistoricAgeYears: 40,
+		Standards: []Standard{
+			{FuelTypes: []string{"PETROL", "HYBRID ELECTRIC", "GAS BI-FUEL", "PETROL/GAS"}, MinEuroStatus: 4, MinYear: 2006},
+			{FuelTypes: []string{"DIESEL", "ELECTRIC DIESEL"}, MinEuroStatus: 6, MinYear: 2016},
+		},
+	},
+}
+
+// LoadZones reads a JSON array of zones from path.
+func LoadZones(path string) ([]Zone, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clean air zones: %w", err)
+	}
+	var zones []Zone
+	if err := json.Unmarshal(data, &zones); err != nil {
+		return nil, fmt.Errorf("failed to parse clean air zones %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i, z := range zones {
+		if z.ID == "" {
+			return nil, fmt.Errorf("clean air zone %d: id is required", i+1)
+		}
+		if seen[z.ID] {
+			return nil, fmt.Errorf("clean air zone %s is defined twice", z.ID)
+		}
+		seen[z.ID] = true
+		if len(z.Charges) == 0 {
+			return nil, fmt.Errorf("clean air zone %s: charges are required", z.ID)
+		}
+		for class, charge := range z.Charges {
+			if charge < 0 {
+				return nil, fmt.Errorf("clean air zone %s: negative charge for %s", z.ID, class)
+			}
+		}
+		for j, s := range z.Standards {
+			if len(s.FuelTypes) == 0 {
+				return nil, fmt.Errorf("clean air zone %s, standard %d: fuel_types is required", z.ID, j+1)
+			}
+		}
+	}
+	return zones, nil
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+// vehicleClass returns the type approval category, e.g. "M1" from "M1" or "M1G".
+func vehicleClass(v *tools.VehicleResponse) string {
+	class := strings.ToUpper(strings.TrimSpace(v.TypeApproval))
+	if len(class) > 2 {
+		class = class[:2]
+	}
+	return class
+}
+
+// Evaluate works out the vehicle's status in the zone.
+func (z Zone) Evaluate(v *tools.VehicleResponse, now time.Time) Result {
+	r := Result{ZoneID: z.ID, ZoneName: z.Name, Currency: z.Currency, Compliant: true}
+	if r.Currency == "" {
+		r.Currency = "GBP"
+	}
+
+	class := vehicleClass(v)
+	charge, charged := z.Charges[class]
+	if !charged {
+		charge, charged = z.Charges["default"]
+	}
+	switch {
+	case !charged:
+		r.Reason = fmt.Sprintf("vehicle class %q is not charged in this zone", class)
+		return r
+	case containsFold(z.ExemptFuelTypes, v.FuelType):
+		r.Exempt = true
+		r.Reason = fmt.Sprintf("%s vehicles are exempt", strings.ToLower(v.FuelType))
+		return r
+	case z.HistoricAgeYears > 0 && v.YearOfManufacture > 0 && now.Year()-v.YearOfManufacture >= z.HistoricAgeYears:
+		r.Exempt = true
+		r.Reason = fmt.Sprintf("historic vehicle built in %d", v.YearOfManufacture)
+		return r
+	}
+
+	var standard *Standard
+	for i := range z.Standards {
+		if containsFold(z.Standards[i].FuelTypes, v.FuelType) {
+			standard = &z.Standards[i]
+			break
+		}
+	}
+	if standard == nil {
+		r.Compliant, r.DailyCharge = false, charge
+		r.Reason = fmt.Sprintf("no emission standard for fuel type %q", v.FuelType)
+		return r
+	}
+
+	failed := ""
+	switch {
+	case v.EuroStatus > 0 && standard.MinEuroStatus > 0:
+		if v.EuroStatus < standard.MinEuroStatus {
+			failed = fmt.Sprintf("Euro %d is below the Euro %d minimum", v.EuroStatus, standard.MinEuroStatus)
+		}
+	case standard.MinYear > 0:
+		if v.YearOfManufacture == 0 {
+			failed = "neither Euro status nor year of manufacture is known"
+		} else if v.YearOfManufacture < standard.MinYear {
+			failed = fmt.Sprintf("built in %d with no Euro status; %s vehicles must be from %d or later", v.YearOfManufacture, strings.ToLower(v.FuelType), standard.MinYear)
+		}
+	}
+	if failed == "" && standard.MaxCO2 > 0 && v.CO2Emissions > standard.MaxCO2 {
+		failed = fmt.Sprintf("CO2 emissions of %d g/km exceed the %d g/km limit", v.CO2Emissions, standard.MaxCO2)
+	}
+
+	if failed != "" {
+		r.Compliant, r.DailyCharge, r.Reason = false, charge, failed
+		return r
+	}
+	r.Reason = "meets the zone's emission standard"
+	return r
+}
+
+// Evaluate checks the vehicle against each zone.
+func Evaluate(zones []Zone, v *tools.VehicleResponse, now time.Time) []Result {
+	results := make([]Result, 0, len(zones))
+	for _, z := range zones {
+		results = append(results, z.Evaluate(v, now))
+	}
+	return results
+}
+
+// --- Process-wide zones ---
+
+var (
+	mu    sync.RWMutex
+	zones = DefaultZones
+)
+
+// Configure loads the zones from CAZZONESPATH, keeping the defaults when unset.
+func Configure(cfg *config.Config) error {
+	loaded := DefaultZones
+	if cfg.CAZZonesPath != "" {
+		var err error
+		if loaded, err = LoadZones(cfg.CAZZonesPath); err != nil {
+			return err
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	zones = loaded
+	return nil
+}
+
+// Zones returns the configured zones.
+func Zones() []Zone {
+	mu.RLock()
+	defer mu.RUnlock()
+	return zones
+}
+
+// Check evaluates a vehicle against the configured zones, limited to the
+// given zone IDs when any are passed. Unknown IDs are an error.
+func Check(v *tools.VehicleResponse, ids ...string) ([]Result, error) {
+	if v == nil {
+		return nil, nil
+	}
+	selected := Zones()
+	if len(ids) > 0 {
+		byID := map[string]Zone{}
+		for _, z := range selected {
+			byID[z.ID] = z
+		}
+		selected = nil
+		for _, id := range ids {
+			z, ok := byID[id]
+			if !ok {
+				return nil, fmt.Errorf("unknown clean air zone %q", id)
+			}
+			selected = append(selected, z)
+		}
+	}
+	return Evaluate(selected, v, time.Now()), nil
+}
@@ -0,0 +1,101 @@
+package clonecheck
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/resilience"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"punkplod23/go-agent-ollama-slm/pkg/webui"
+	"strings"
+	"time"
+)
+
+// visionPrompt asks for a machine-readable answer; models often wrap JSON
+// in prose or code fences, so parseAttributes looks for the object itself.
+const visionPrompt = `Look at the main vehicle in this image. Reply with only a JSON object of the form {"colour": "...", "make": "..."}. Use the manufacturer name for make (e.g. "FORD") and a single basic colour word for colour (e.g. "SILVER"). Use "unknown" for anything you cannot tell.`
+
+// VisionClassifier asks a vision-capable model behind Open WebUI.
+type VisionClassifier struct {
+	Config *config.Config
+	Model  string // empty uses OPENWEBUIMODELNAME
+}
+
+func (v *VisionClassifier) Classify(image *tools.PreparedImage) (*Attributes, error) {
+	reply, err := webui.DescribeImage(v.Config, v.Model, visionPrompt, image.DataURI())
+	if err != nil {
+		return nil, err
+	}
+	return parseAttributes(reply)
+}
+
+func parseAttributes(reply string) (*Attributes, error) {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("model reply has no JSON object: %q", reply)
+	}
+	var attrs Attributes
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &attrs); err != nil {
+		return nil, fmt.Errorf("failed to parse model reply %q: %w", reply, err)
+	}
+	return &attrs, nil
+}
+
+// ClassifierPolicy retries the HTTP classifier; classifying an image twice is harmless.
+var ClassifierPolicy = resilience.Policy{
+	MaxAttempts:        3,
+	BaseDelay:          300 * time.Millisecond,
+	MaxDelay:           3 * time.Second,
+	RetryNonIdempotent: true,
+	FailureThreshold:   5,
+	OpenDuration:       30 * time.Second,
+}
+
+// HTTPClassifier posts {"image_base64": "data:..."} to an external service
+// that replies with {"colour": "...", "make": "..."}.
+type HTTPClassifier struct {
+	URL    string
+	client *http.Client
+}
+
+func NewHTTPClassifier(url string) *HTTPClassifier {
+	return &HTTPClassifier{
+		URL:    url,
+		client: resilience.NewClient("clone-classifier", egress.Transport(), ClassifierPolicy, 30*time.Second),
+	}
+}
+
+func (h *HTTPClassifier) Classify(image *tools.PreparedImage) (*Attributes, error) {
+	body, err := json.Marshal(map[string]string{
+		"image_base64": image.DataURI(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal classifier request: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create classifier request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("classifier request to %s failed: %w", h.URL, err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("classifier %s returned status %d: %s", h.URL, resp.StatusCode, string(responseBody))
+	}
+	var attrs Attributes
+	if err := json.Unmarshal(responseBody, &attrs); err != nil {
+		return nil, fmt.Errorf("failed to decode classifier response: %w", err)
+	}
+	return &attrs, nil
+}
@@ -0,0 +1,236 @@
+// Package clonecheck looks for cloned plates by comparing the colour and make
+// of the vehicle in the image with the DVSA record for the plate it carries.
+package clonecheck
+
+import (
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+	"sync"
+)
+
+// Attributes are what a classifier sees in the image. Empty fields are unknown.
+type Attributes struct {
+	Colour string `json:"colour"`
+	Make   string `json:"make"`
+}
+
+// Classifier works out a vehicle's attributes from an image.
+type Classifier interface {
+	Classify(image *tools.PreparedImage) (*Attributes, error)
+}
+
+// Field comparison outcomes.
+const (
+	Match    = "match"
+	Similar  = "similar" // e.g. silver against grey, easily confused on camera
+	Mismatch = "mismatch"
+	Unknown  = "unknown" // the classifier could not tell
+)
+
+// Weights of each attribute in the mismatch score. A make mismatch is
+// stronger evidence than colour, which lighting and resprays can change.
+const (
+	colourWeight = 0.4
+	makeWeight   = 0.6
+	// similarFactor is the share of the weight a Similar outcome contributes.
+	similarFactor = 0.25
+)
+
+// Result compares the observed attributes with the DVSA record.
+type Result struct {
+	Registration   string  `json:"registration"`
+	ObservedColour string  `json:"observed_colour,omitempty"`
+	RecordedColour string  `json:"recorded_colour,omitempty"`
+	Colour         string  `json:"colour"`
+	ObservedMake   string  `json:"observed_make,omitempty"`
+	RecordedMake   string  `json:"recorded_make,omitempty"`
+	Make           string  `json:"make"`
+	Score          float64 `json:"score"` // 0 is consistent with the record, 1 contradicts it on every attribute
+	Suspected      bool    `json:"suspected_clone"`
+}
+
+// colourGroups collects DVSA colours that cameras commonly confuse.
+var colourGroups = [][]string{
+	{"SILVER", "GREY"},
+	{"BEIGE", "CREAM", "GOLD", "BRONZE", "BROWN"},
+	{"RED", "MAROON", "PINK"},
+	{"BLUE", "TURQUOISE", "PURPLE"},
+	{"YELLOW", "GOLD", "ORANGE"},
+	{"WHITE", "CREAM"},
+}
+
+// colourAliases maps free-text colours onto DVSA's colour names.
+var colourAliases = map[string]string{
+	"GRAY": "GREY", "CHARCOAL": "GREY", "GUNMETAL": "GREY",
+	"NAVY": "BLUE", "DARK BLUE": "BLUE", "LIGHT BLUE": "BLUE",
+	"BURGUNDY": "MAROON", "WINE": "MAROON",
+	"TAN": "BEIGE", "CHAMPAGNE": "BEIGE",
+	"LIME": "GREEN", "DARK GREEN": "GREEN",
+	"VIOLET": "PURPLE", "TEAL": "TURQUOISE",
+	"MULTI": "MULTI-COLOUR", "MULTICOLOUR": "MULTI-COLOUR",
+}
+
+// makeAliases maps common short or informal names onto DVSA's make names.
+var makeAliases = map[string]string{
+	"VW": "VOLKSWAGEN", "MERC": "MERCEDES-BENZ", "MERCEDES": "MERCEDES-BENZ", "MERCEDES BENZ": "MERCEDES-BENZ",
+	"BEEMER": "BMW", "CHEVY": "CHEVROLET", "RANGE ROVER": "LAND ROVER", "LANDROVER": "LAND ROVER",
+	"ALFA": "ALFA ROMEO", "MINI COOPER": "MINI", "ROLLS": "ROLLS ROYCE", "ROLLS-ROYCE": "ROLLS ROYCE",
+}
+
+func normalise(v string, aliases map[string]string) string {
+	v = strings.ToUpper(strings.Join(strings.Fields(v), " "))
+	switch v {
+	case "", "UNKNOWN", "N/A", "NONE":
+		return ""
+	}
+	if alias, ok := aliases[v]; ok {
+		return alias
+	}
+	return v
+}
+
+func compareColour(observed, recorded string) string {
+	if observed == "" || recorded == "" {
+		return Unknown
+	}
+	if observed == recorded {
+		return Match
+	}
+	for _, group := range colourGroups {
+		inObserved, inRecorded := false, false
+		for _, c := range group {
+			inObserved = inObserved || c == observed
+			inRecorded = inRecorded || c == recorded
+		}
+		if inObserved && inRecorded {
+			return Similar
+		}
+	}
+	return Mismatch
+}
+
+func compareMake(observed, recorded string) string {
+	if observed == "" || recorded == "" {
+		return Unknown
+	}
+	// DVSA sometimes appends the body maker, e.g. "FORD" vs "FORD TRANSIT".
+	if observed == recorded || strings.HasPrefix(recorded, observed+" ") || strings.HasPrefix(observed, recorded+" ") {
+		return Match
+	}
+	return Mismatch
+}
+
+func contribution(outcome string, weight float64) (score, counted float64) {
+	switch outcome {
+	case Mismatch:
+		return weight, weight
+	case Similar:
+		return weight * similarFactor, weight
+	case Match:
+		return 0, weight
+	}
+	return 0, 0
+}
+
+// Compare scores observed attributes against a DVSA record. The score is
+// the weighted share of the known attributes that disagree, so a single
+// unknown attribute does not dilute a clear mismatch on the other.
+func Compare(observed Attributes, vehicle *tools.VehicleResponse, threshold float64) Result {
+	r := Result{
+		Registration:   vehicle.RegistrationNumber,
+		ObservedColour: normalise(observed.Colour, colourAliases),
+		RecordedColour: normalise(vehicle.Colour, colourAliases),
+		ObservedMake:   normalise(observed.Make, makeAliases),
+		RecordedMake:   normalise(vehicle.Make, makeAliases),
+	}
+	r.Colour = compareColour(r.ObservedColour, r.RecordedColour)
+	r.Make = compareMake(r.ObservedMake, r.RecordedMake)
+
+	colourScore, colourCounted := contribution(r.Colour, colourWeight)
+	makeScore, makeCounted := contribution(r.Make, makeWeight)
+	if total := colourCounted + makeCounted; total > 0 {
+		r.Score = (colourScore + makeScore) / total
+	}
+	r.Suspected = r.Score >= threshold
+	return r
+}
+
+// --- Process-wide checker ---
+
+// DefaultThreshold is the suspicion threshold used when CLONESCORETHRESHOLD
+// is not set.
+const DefaultThreshold = 0.5
+
+var (
+	mu         sync.RWMutex
+	classifier Classifier
+	threshold  = DefaultThreshold
+)
+
+// Configure selects the classifier named by CLONECLASSIFIER: "vision" asks an
+// Open WebUI vision model, "http" posts the image to CLONECLASSIFIERURL, and
+// an empty value turns the check off.
+func Configure(cfg *config.Config) error {
+	var c Classifier
+	switch strings.ToLower(cfg.CloneClassifier) {
+	case "":
+	case "vision":
+		c = &VisionClassifier{Config: cfg, Model: cfg.CloneVisionModel}
+	case "http":
+		if cfg.CloneClassifierURL == "" {
+			return fmt.Errorf("CLONECLASSIFIERURL is required for the http classifier")
+		}
+		c = NewHTTPClassifier(cfg.CloneClassifierURL)
+	default:
+		return fmt.Errorf("unknown CLONECLASSIFIER %q: use vision or http", cfg.CloneClassifier)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	classifier = c
+	threshold = DefaultThreshold
+	if cfg.CloneScoreThreshold > 0 {
+		threshold = cfg.CloneScoreThreshold
+	}
+	return nil
+}
+
+// SetClassifier replaces the classifier, e.g. with a custom implementation.
+// A nil classifier turns the check off.
+func SetClassifier(c Classifier) {
+	mu.Lock()
+	defer mu.Unlock()
+	classifier = c
+}
+
+// Enabled reports whether a classifier is configured.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return classifier != nil
+}
+
+// Check classifies the image and compares it with the vehicle record. It
+// returns nil when no classifier is configured.
+func Check(image *tools.PreparedImage, vehicle *tools.VehicleResponse) (*Result, error) {
+	mu.RLock()
+	c, t := classifier, threshold
+	mu.RUnlock()
+	if c == nil || vehicle == nil {
+		return nil, nil
+	}
+
+	observed, err := c.Classify(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to classify vehicle: %w", err)
+	}
+	result := Compare(*observed, vehicle, t)
+	if result.Suspected {
+		logging.Warnf("🚨 POSSIBLE CLONED PLATE: %s looks like a %s %s, DVSA has a %s %s (score %.2f)",
+			result.Registration, result.ObservedColour, result.ObservedMake, result.RecordedColour, result.RecordedMake, result.Score)
+	}
+	return &result, nil
+}
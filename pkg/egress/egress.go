@@ -0,0 +1,401 @@
+// Package egress enforces a single network policy on every outbound
+// connection the service makes. All upstream clients dial through
+// DialContext, so the policy is the one place that decides which addresses
+// and ports can be reached.
+package egress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDenied is returned when the policy refuses a connection.
+var ErrDenied = errors.New("egress denied")
+
+// AlwaysBlocked covers cloud metadata services and link-local addresses. They
+// are refused even when an allowed CIDR would otherwise include them.
+var AlwaysBlocked = []string{
+	"169.254.0.0/16",     // IPv4 link-local, including 169.254.169.254 metadata
+	"fe80::/10",          // IPv6 link-local
+	"fd00:ec2::254/128",  // AWS IPv6 metadata
+	"100.100.100.200/32", // Alibaba Cloud metadata
+	"0.0.0.0/8",
+	"::/128",
+}
+
+// Policy decides which connections may be made.
+type Policy struct {
+	// AllowedCIDRs limits destinations to these ranges. When empty, pinned
+	// host addresses may be any address that is not blocked, but an IP
+	// literal is only dialled if it is listed in AllowedHosts.
+	AllowedCIDRs []*net.IPNet
+	BlockedCIDRs []*net.IPNet
+	// AllowedHosts lists the hostnames that may be dialled. Each is resolved
+	// at most once and then pinned, so a later DNS change cannot redirect
+	// traffic. Hosts configured as host=ip are never resolved, and an IP
+	// literal is pinned to itself.
+	AllowedHosts map[string][]net.IP
+	// AllowedPorts limits destination ports; empty allows any port.
+	AllowedPorts map[int]bool
+
+	mu sync.Mutex
+	// source is the settings the policy was built from, so a reload that
+	// leaves them alone keeps the policy and its pinned addresses.
+	source string
+	// generation counts the policies put in force; connections remember
+	// the one they were dialled under.
+	generation uint64
+}
+
+// PolicySummary is the policy in a form suitable for reporting.
+type PolicySummary struct {
+	AllowedCIDRs []string            `json:"allowed_cidrs"`
+	BlockedCIDRs []string            `json:"blocked_cidrs"`
+	AllowedHosts map[string][]string `json:"allowed_hosts"` // host -> pinned addresses, empty until first use
+	AllowedPorts []int               `json:"allowed_ports"`
+	Denied       int64               `json:"denied_total"`
+}
+
+var denied atomic.Int64
+
+// Denied returns the number of connections refused since start-up.
+func Denied() int64 { return denied.Load() }
+
+func parseCIDRs(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range splitList(list) {
+		if !strings.Contains(item, "/") {
+			if ip := net.ParseIP(item); ip != nil && ip.To4() != nil {
+				item += "/32"
+			} else {
+				item += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", item, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// NewPolicy builds a policy from comma-separated lists. hosts entries are
+// "name" (resolved once, on first use), "name=ip|ip" (pinned) or an IP
+// literal.
+func NewPolicy(allowedCIDRs, blockedCIDRs, hosts, ports string) (*Policy, error) {
+	p := &Policy{AllowedHosts: map[string][]net.IP{}, AllowedPorts: map[int]bool{}}
+	p.source = strings.Join([]string{allowedCIDRs, blockedCIDRs, hosts, ports}, "\x00")
+
+	var err error
+	if p.AllowedCIDRs, err = parseCIDRs(allowedCIDRs); err != nil {
+		return nil, fmt.Errorf("allowed CIDRs: %w", err)
+	}
+	if p.BlockedCIDRs, err = parseCIDRs(strings.Join(AlwaysBlocked, ",") + "," + blockedCIDRs); err != nil {
+		return nil, fmt.Errorf("blocked CIDRs: %w", err)
+	}
+
+	for _, entry := range splitList(hosts) {
+		name, pinned, _ := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			return nil, fmt.Errorf("invalid allowed host %q", entry)
+		}
+		var ips []net.IP
+		if ip := net.ParseIP(name); ip != nil {
+			name, ips = ip.String(), []net.IP{ip}
+		}
+		for _, s := range strings.Split(pinned, "|") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid pinned address %q for host %s", s, name)
+			}
+			ips = append(ips, ip)
+		}
+		p.AllowedHosts[name] = ips
+	}
+
+	for _, s := range splitList(ports) {
+		port, err := strconv.Atoi(s)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %q", s)
+		}
+		p.AllowedPorts[port] = true
+	}
+	return p, nil
+}
+
+// CheckIP reports why an address is refused, or nil if it may be dialled.
+func (p *Policy) CheckIP(ip net.IP) error {
+	for _, n := range p.BlockedCIDRs {
+		if n.Contains(ip) {
+			return fmt.Errorf("%w: %s is in blocked range %s", ErrDenied, ip, n)
+		}
+	}
+	if len(p.AllowedCIDRs) == 0 {
+		return nil
+	}
+	for _, n := range p.AllowedCIDRs {
+		if n.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s is not in an allowed range", ErrDenied, ip)
+}
+
+// CheckPort reports whether the port is refused.
+func (p *Policy) CheckPort(port int) error {
+	if len(p.AllowedPorts) > 0 && !p.AllowedPorts[port] {
+		return fmt.Errorf("%w: port %d is not allowed", ErrDenied, port)
+	}
+	return nil
+}
+
+// resolve returns the pinned addresses for an allowed host, resolving and
+// pinning them on first use.
+func (p *Policy) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ips, ok := p.AllowedHosts[host]
+	if !ok {
+		return nil, fmt.Errorf("%w: host %q is not on the allow-list", ErrDenied, host)
+	}
+	if len(ips) > 0 {
+		return ips, nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, a := range addrs {
+		ips = append(ips, a.IP)
+	}
+	p.AllowedHosts[host] = ips
+	logging.Infof("egress: pinned %s to %v", host, ips)
+	return ips, nil
+}
+
+// listed reports whether an IP literal is one of the allowed hosts.
+func (p *Policy) listed(ip net.IP) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.AllowedHosts[ip.String()]
+	return ok
+}
+
+// DialContext dials addr if the policy allows it. Hostnames must be on the
+// allow-list and are dialled at their pinned addresses; every address,
+// pinned or literal, must pass the CIDR rules. Without allowed CIDRs, IP
+// literals must be on the host allow-list too.
+func (p *Policy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := p.dial(ctx, network, addr)
+	if errors.Is(err, ErrDenied) {
+		denied.Add(1)
+		logging.Warnf("egress: %v", err)
+	}
+	return conn, err
+}
+
+func (p *Policy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrDenied, addr)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port in %q", ErrDenied, addr)
+	}
+	if err := p.CheckPort(port); err != nil {
+		return nil, err
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = p.resolve(ctx, strings.ToLower(host)); err != nil {
+			return nil, err
+		}
+	} else if len(p.AllowedCIDRs) == 0 && !p.listed(ips[0]) {
+		return nil, fmt.Errorf("%w: address %s is not on the allow-list", ErrDenied, ips[0])
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	var lastErr error
+	for _, ip := range ips {
+		if err := p.CheckIP(ip); err != nil {
+			lastErr = err
+			continue
+		}
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), portStr))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Summary describes the policy.
+func (p *Policy) Summary() PolicySummary {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := PolicySummary{AllowedCIDRs: []string{}, BlockedCIDRs: []string{}, AllowedHosts: map[string][]string{}, AllowedPorts: []int{}, Denied: Denied()}
+	for _, n := range p.AllowedCIDRs {
+		s.AllowedCIDRs = append(s.AllowedCIDRs, n.String())
+	}
+	for _, n := range p.BlockedCIDRs {
+		s.BlockedCIDRs = append(s.BlockedCIDRs, n.String())
+	}
+	for host, ips := range p.AllowedHosts {
+		pinned := []string{}
+		for _, ip := range ips {
+			pinned = append(pinned, ip.String())
+		}
+		s.AllowedHosts[host] = pinned
+	}
+	for port := range p.AllowedPorts {
+		s.AllowedPorts = append(s.AllowedPorts, port)
+	}
+	sort.Ints(s.AllowedPorts)
+	return s
+}
+
+// --- Process-wide policy ---
+
+var current atomic.Pointer[Policy]
+
+func init() {
+	p, _ := NewPolicy("", "", "", "")
+	current.Store(p)
+}
+
+// upstreamHosts returns the hosts of the upstream URLs in the configuration,
+// hostnames and IP literals alike, which are allowed when
+// EGRESSALLOWEDHOSTS is not set.
+func upstreamHosts(cfg *config.Config) []string {
+	urls := []string{cfg.OpenWebUIHostURL, cfg.DVSAAPIURL, cfg.OpenALPRAPIURL, cfg.WatchlistWebhookURL, cfg.GateWebhookURL, cfg.CloneClassifierURL, cfg.OllamaURL}
+	if strings.EqualFold(cfg.AlertSink, "webhook") {
+		urls = append(urls, cfg.AlertSinkTarget)
+	}
+
+	var hosts []string
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Hostname() == "" {
+			continue
+		}
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts
+}
+
+// Configure builds the policy from the configuration. When no hostnames are
+// listed, the hosts of the configured upstream URLs are allowed.
+//
+// A changed policy takes effect on open connections too: idle pooled
+// connections are closed, and connections dialled under the old policy fail
+// on their next read or write, so a removed host or range cannot be reached
+// over a kept-alive connection.
+func Configure(cfg *config.Config) error {
+	hosts := cfg.EgressAllowedHosts
+	if hosts == "" {
+		hosts = strings.Join(upstreamHosts(cfg), ",")
+	}
+	p, err := NewPolicy(cfg.EgressAllowedCIDRs, cfg.EgressBlockedCIDRs, hosts, cfg.EgressAllowedPorts)
+	if err != nil {
+		return fmt.Errorf("invalid egress policy: %w", err)
+	}
+	old := current.Load()
+	if old.source == p.source {
+		return nil
+	}
+	p.generation = old.generation + 1
+	current.Store(p)
+	sharedTransport.CloseIdleConnections()
+	return nil
+}
+
+// Current returns the policy in force.
+func Current() *Policy { return current.Load() }
+
+// DialContext dials through the policy in force. The connection stops
+// working once a different policy is configured.
+func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	p := Current()
+	conn, err := p.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &policyConn{Conn: conn, generation: p.generation}, nil
+}
+
+// policyConn is a connection dialled under one generation of the policy.
+type policyConn struct {
+	net.Conn
+	generation uint64
+}
+
+// stale closes the connection if the policy has changed since it was dialled.
+func (c *policyConn) stale() error {
+	if current.Load().generation == c.generation {
+		return nil
+	}
+	c.Conn.Close()
+	denied.Add(1)
+	return fmt.Errorf("%w: connection to %s was opened under a replaced policy", ErrDenied, c.RemoteAddr())
+}
+
+func (c *policyConn) Read(b []byte) (int, error) {
+	if err := c.stale(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *policyConn) Write(b []byte) (int, error) {
+	if err := c.stale(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+// NewTransport returns an HTTP transport that dials through the policy.
+// Proxies are never used, since a proxy would hide the real destination.
+func NewTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = DialContext
+	return t
+}
+
+var sharedTransport = NewTransport()
+
+// Transport returns the shared policy-enforcing transport for upstream clients.
+func Transport() *http.Transport { return sharedTransport }
@@ -0,0 +1,144 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"punkplod23/go-agent-ollama-slm/config"
+	"testing"
+)
+
+func listen(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestIPLiteralsNeedListing(t *testing.T) {
+	addr := listen(t)
+	tests := []struct {
+		name                string
+		allowedCIDRs, hosts string
+		blockedCIDRs        string
+		wantDenied          bool
+	}{
+		{name: "unlisted", wantDenied: true},
+		{name: "listed host", hosts: "127.0.0.1"},
+		{name: "allowed range", allowedCIDRs: "127.0.0.0/8"},
+		{name: "range excludes it", allowedCIDRs: "10.0.0.0/8", wantDenied: true},
+		{name: "blocked beats listed", hosts: "127.0.0.1", blockedCIDRs: "127.0.0.0/8", wantDenied: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy(tt.allowedCIDRs, tt.blockedCIDRs, tt.hosts, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := p.DialContext(context.Background(), "tcp", addr)
+			if conn != nil {
+				conn.Close()
+			}
+			if denied := errors.Is(err, ErrDenied); denied != tt.wantDenied {
+				t.Errorf("denied = %v (%v), want %v", denied, err, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestMetadataAlwaysBlocked(t *testing.T) {
+	p, err := NewPolicy("0.0.0.0/0", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.CheckIP(net.ParseIP("169.254.169.254")); !errors.Is(err, ErrDenied) {
+		t.Errorf("metadata address allowed: %v", err)
+	}
+}
+
+func TestUnlistedHostnameDenied(t *testing.T) {
+	p, err := NewPolicy("", "", "api.example.com=192.0.2.10", "443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.DialContext(context.Background(), "tcp", "other.example.com:443"); !errors.Is(err, ErrDenied) {
+		t.Errorf("unlisted host: got %v, want ErrDenied", err)
+	}
+	if _, err := p.DialContext(context.Background(), "tcp", "api.example.com:80"); !errors.Is(err, ErrDenied) {
+		t.Errorf("unlisted port: got %v, want ErrDenied", err)
+	}
+}
+
+// A host removed on reload must not stay reachable over a kept-alive
+// connection from before the reload.
+func TestReloadDropsConnectionsFromOldPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+	t.Cleanup(func() { Configure(&config.Config{}) })
+
+	if err := Configure(&config.Config{OpenWebUIHostURL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: Transport()}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("allowed host: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Keep a connection open across the reload, as a request in flight would.
+	conn, err := DialContext(context.Background(), "tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := Configure(&config.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrDenied) {
+		t.Errorf("removed host after reload: got %v, want ErrDenied", err)
+	}
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\n\r\n")); !errors.Is(err, ErrDenied) {
+		t.Errorf("connection from the old policy: got %v, want ErrDenied", err)
+	}
+}
+
+func TestReloadWithSamePolicyKeepsConnections(t *testing.T) {
+	addr := listen(t)
+	cfg := &config.Config{EgressAllowedHosts: "127.0.0.1"}
+	t.Cleanup(func() { Configure(&config.Config{}) })
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := DialContext(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.(*policyConn).stale(); err != nil {
+		t.Errorf("unchanged policy dropped the connection: %v", err)
+	}
+}
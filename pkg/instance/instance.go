@@ -0,0 +1,45 @@
+// Package instance holds the process-wide objects that main builds at
+// start-up, such as the watchlist and sighting stores, for the packages whose
+// handlers and helpers share them.
+package instance
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// Of holds one process-wide *T. main installs it with Set; until then Get
+// builds a fallback once, so handlers and tests work without start-up.
+type Of[T any] struct {
+	value    atomic.Pointer[T]
+	once     sync.Once
+	fallback func() (*T, error)
+}
+
+// New returns a holder whose fallback is built by fallback on first use.
+func New[T any](fallback func() (*T, error)) *Of[T] {
+	return &Of[T]{fallback: fallback}
+}
+
+// Set installs v, replacing any fallback already in use.
+func (o *Of[T]) Set(v *T) {
+	o.value.Store(v)
+}
+
+// Get returns the installed value, or the fallback when none is installed.
+// The fallbacks are in-memory stores, so failing to build one is a bug and
+// panics rather than handing callers nil.
+func (o *Of[T]) Get() *T {
+	if v := o.value.Load(); v != nil {
+		return v
+	}
+	o.once.Do(func() {
+		v, err := o.fallback()
+		if err != nil {
+			panic(fmt.Sprintf("instance: failed to build fallback %T: %v", v, err))
+		}
+		o.value.CompareAndSwap(nil, v)
+	})
+	return o.value.Load()
+}
@@ -0,0 +1,41 @@
+package instance_test
+
+import (
+	"punkplod23/go-agent-ollama-slm/pkg/instance"
+	"sync"
+	"testing"
+)
+
+func TestFallbackBuiltOnceUntilSet(t *testing.T) {
+	builds := 0
+	holder := instance.New(func() (*string, error) {
+		builds++
+		v := "fallback"
+		return &v, nil
+	})
+
+	var wg sync.WaitGroup
+	got := make([]*string, 8)
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = holder.Get()
+		}()
+	}
+	wg.Wait()
+	for _, v := range got {
+		if v != got[0] {
+			t.Fatal("concurrent callers got different fallbacks")
+		}
+	}
+	if builds != 1 {
+		t.Errorf("fallback built %d times, want 1", builds)
+	}
+
+	configured := "configured"
+	holder.Set(&configured)
+	if v := holder.Get(); *v != "configured" {
+		t.Errorf("after Set: got %q", *v)
+	}
+}
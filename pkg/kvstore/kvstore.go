@@ -0,0 +1,268 @@
+// Package kvstore is a small embedded key-value store backed by an
+// append-only JSON-lines log. The whole data set is held in memory and the
+// log is replayed on open, which suits the modest volumes this service keeps
+// locally (owner mappings, watchlists, sightings) without an external database.
+package kvstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// compactionRatio triggers a rewrite once the log holds this many entries per live key.
+const compactionRatio = 2
+
+type logEntry struct {
+	Op    string          `json:"op"` // "put" or "del"
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Store is a persistent map of string keys to JSON values. It is safe for concurrent use.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	data    map[string]json.RawMessage
+	entries int
+}
+
+// Open loads the store at path, creating the file and its directory if needed.
+// An empty path gives a store that lives only in memory.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, data: make(map[string]json.RawMessage)}
+	if path == "" {
+		return s, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store %s: %w", path, err)
+	}
+	s.file = file
+	return s, nil
+}
+
+func (s *Store) replay() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open store %s: %w", s.path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry logEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn final write is expected after a crash; anything earlier is corruption.
+			if !hasMore(scanner) {
+				break
+			}
+			return fmt.Errorf("store %s is corrupt at line %d: %w", s.path, line, err)
+		}
+		s.apply(entry)
+		s.entries++
+	}
+	return scanner.Err()
+}
+
+func hasMore(scanner *bufio.Scanner) bool {
+	for scanner.Scan() {
+		if len(scanner.Bytes()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Store) apply(entry logEntry) {
+	switch entry.Op {
+	case "put":
+		s.data[entry.Key] = entry.Value
+	case "del":
+		delete(s.data, entry.Key)
+	}
+}
+
+func (s *Store) write(entry logEntry) error {
+	if s.file == nil {
+		s.apply(entry)
+		return nil
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write to store %s: %w", s.path, err)
+	}
+	s.apply(entry)
+	s.entries++
+
+	// The entry is already in the log, so a failed compaction is not the
+	// caller's error; the log keeps growing and the next write retries.
+	if s.entries > 64 && s.entries > compactionRatio*len(s.data) {
+		if err := s.compactLocked(); err != nil {
+			logging.Warnf("kvstore: %v", err)
+		}
+	}
+	return nil
+}
+
+// Put stores value under key, replacing any existing value.
+func (s *Store) Put(key string, value interface{}) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode value for %s: %w", key, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(logEntry{Op: "put", Key: key, Value: raw})
+}
+
+// Get decodes the value stored under key into target. It reports false if the key is absent.
+func (s *Store) Get(key string, target interface{}) (bool, error) {
+	s.mu.RLock()
+	raw, ok := s.data[key]
+	s.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, target); err != nil {
+		return true, fmt.Errorf("failed to decode value for %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data[key]; !ok {
+		return nil
+	}
+	return s.write(logEntry{Op: "del", Key: key})
+}
+
+// Keys returns every key with the given prefix in ascending order.
+func (s *Store) Keys(prefix string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var keys []string
+	for k := range s.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Scan calls fn for every key with the given prefix in ascending key order,
+// stopping early if fn returns false. fn must not modify the store.
+func (s *Store) Scan(prefix string, fn func(key string, value json.RawMessage) bool) {
+	keys := s.Keys(prefix)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, k := range keys {
+		v, ok := s.data[k]
+		if !ok {
+			continue
+		}
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// Compact rewrites the log so it holds exactly one entry per live key.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.compactLocked()
+}
+
+// compactLocked writes the live keys to a new log and renames it over the
+// old one. The new log is opened for appending up front, so it becomes the
+// store's file only once the rename has succeeded; until then the old log
+// stays in place and in use.
+func (s *Store) compactLocked() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to compact store %s: %w", s.path, err)
+	}
+	discard := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for k, v := range s.data {
+		line, err := json.Marshal(logEntry{Op: "put", Key: k, Value: v})
+		if err != nil {
+			return discard(err)
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		return discard(fmt.Errorf("failed to compact store %s: %w", s.path, err))
+	}
+	if err := tmp.Sync(); err != nil {
+		return discard(fmt.Errorf("failed to compact store %s: %w", s.path, err))
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return discard(fmt.Errorf("failed to replace store %s: %w", s.path, err))
+	}
+
+	s.file.Close()
+	s.file = tmp
+	s.entries = len(s.data)
+	return nil
+}
+
+// Close flushes the log to disk and releases the file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,41 @@
+package kvstore_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/pkg/kvstore"
+	"testing"
+)
+
+func TestCompactionKeepsAppending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.jsonl")
+	db, err := kvstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough overwrites of a few keys to trigger compaction several times.
+	for i := 0; i < 500; i++ {
+		if err := db.Put(fmt.Sprintf("k%d", i%3), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".compact"); !os.IsNotExist(err) {
+		t.Errorf("compaction left its temporary file behind: %v", err)
+	}
+
+	db, err = kvstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for k, want := range map[string]int{"k0": 498, "k1": 499, "k2": 497} {
+		var got int
+		if ok, err := db.Get(k, &got); err != nil || !ok || got != want {
+			t.Errorf("%s = %d (found %v, %v), want %d", k, got, ok, err, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package logging filters log output by level. The level is process-wide
+// and can be changed while running, so LOGLEVEL takes effect on reload.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"punkplod23/go-agent-ollama-slm/config"
+	"strings"
+	"sync/atomic"
+)
+
+// Level is a log verbosity; messages below the configured level are dropped.
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var names = map[string]Level{"debug": Debug, "info": Info, "warn": Warn, "error": Error}
+
+var current atomic.Int32
+
+func init() { current.Store(int32(Info)) }
+
+// ParseLevel reads a level name; "" means info.
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return Info, nil
+	}
+	l, ok := names[strings.ToLower(name)]
+	if !ok {
+		return Info, fmt.Errorf("unknown log level %q: use debug, info, warn or error", name)
+	}
+	return l, nil
+}
+
+// SetLevel changes the level in force.
+func SetLevel(l Level) { current.Store(int32(l)) }
+
+// Configure applies LOGLEVEL.
+func Configure(cfg *config.Config) error {
+	l, err := ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	SetLevel(l)
+	return nil
+}
+
+// Enabled reports whether messages at l are logged.
+func Enabled(l Level) bool { return l >= Level(current.Load()) }
+
+func logf(l Level, format string, args ...interface{}) {
+	if Enabled(l) {
+		log.Output(3, fmt.Sprintf(format, args...))
+	}
+}
+
+// Debugf logs detail that is only useful when diagnosing a problem.
+func Debugf(format string, args ...interface{}) { logf(Debug, format, args...) }
+
+// Infof logs normal operation.
+func Infof(format string, args ...interface{}) { logf(Info, format, args...) }
+
+// Warnf logs something unexpected that the service recovered from.
+func Warnf(format string, args ...interface{}) { logf(Warn, format, args...) }
+
+// Errorf logs a failure.
+func Errorf(format string, args ...interface{}) { logf(Error, format, args...) }
@@ -0,0 +1,101 @@
+// Package notify delivers event payloads to caller-configured webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/resilience"
+	"punkplod23/go-agent-ollama-slm/pkg/safefetch"
+	"sync"
+	"time"
+)
+
+// WebhookPolicy retries webhook deliveries. Receivers are expected to
+// de-duplicate on the Idempotency-Key header we send with every event.
+var WebhookPolicy = resilience.Policy{
+	MaxAttempts:      3,
+	BaseDelay:        500 * time.Millisecond,
+	MaxDelay:         5 * time.Second,
+	FailureThreshold: 10,
+	OpenDuration:     time.Minute,
+}
+
+// pending counts deliveries started with Go, so shutdown can wait for them.
+var pending sync.WaitGroup
+
+// Go runs a delivery in the background, tracked so Wait can let it finish.
+func Go(deliver func()) {
+	pending.Add(1)
+	go func() {
+		defer pending.Done()
+		deliver()
+	}()
+}
+
+// Wait blocks until every background delivery has finished, or ctx ends.
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook deliveries still running at shutdown: %w", ctx.Err())
+	}
+}
+
+// PostJSON sends payload to an operator-configured url as a JSON POST.
+// eventID is sent as the Idempotency-Key header so retried deliveries can be
+// recognised.
+func PostJSON(url, eventID string, payload interface{}) error {
+	client := resilience.NewClient("webhook", egress.Transport(), WebhookPolicy, 15*time.Second)
+	return post(client, url, eventID, payload)
+}
+
+// PostJSONToCallback is PostJSON for callback URLs supplied through the API.
+// Delivery goes through the SSRF-safe fetcher, so a callback can never point
+// at a private or metadata address, directly or by redirect.
+func PostJSONToCallback(url, eventID string, payload interface{}) error {
+	if _, err := safefetch.ValidateURL(url); err != nil {
+		return fmt.Errorf("refusing callback %s: %w", url, err)
+	}
+	client := resilience.NewClient("callback", safefetch.Transport(), WebhookPolicy, safefetch.CurrentLimits().Timeout)
+	client.CheckRedirect = safefetch.CheckRedirect
+	return post(client, url, eventID, payload)
+}
+
+func post(client *http.Client, url, eventID string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if eventID != "" {
+		req.Header.Set("Idempotency-Key", eventID)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook delivery to %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("webhook %s returned status %d: %s", url, resp.StatusCode, string(responseBody))
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// Package ollama reads model metadata straight from an Ollama server, for
+// deployments that can reach Ollama as well as Open WebUI. Chats still go
+// through Open WebUI; this is only used to describe the installed models.
+package ollama
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/resilience"
+	"strings"
+	"time"
+)
+
+// Policy is the retry and circuit breaker policy for Ollama calls.
+var Policy = resilience.DefaultPolicy
+
+var transport = cassette.Wrap("ollama", egress.Transport())
+
+func getClient() *http.Client {
+	return resilience.NewClient("ollama", transport, Policy, 10*time.Second)
+}
+
+// Details is the metadata Ollama reports for a model.
+type Details struct {
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// Model is one entry from /api/tags.
+type Model struct {
+	Name       string    `json:"name"`
+	Model      string    `json:"model"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Size       int64     `json:"size"`
+	Digest     string    `json:"digest"`
+	Details    Details   `json:"details"`
+}
+
+type tagsResponse struct {
+	Models []Model `json:"models"`
+}
+
+type showResponse struct {
+	ModelInfo map[string]interface{} `json:"model_info"`
+}
+
+// Tags lists the models installed on the Ollama server at OLLAMAURL.
+func Tags(cfg *config.Config) ([]Model, error) {
+	var response tagsResponse
+	if err := call(cfg, "GET", "/api/tags", nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to list Ollama models: %w", err)
+	}
+	return response.Models, nil
+}
+
+// ContextLength returns the context window a model was trained with, from
+// /api/show, or 0 when Ollama does not report one.
+func ContextLength(cfg *config.Config, model string) (int, error) {
+	var response showResponse
+	if err := call(cfg, "POST", "/api/show", map[string]string{"model": model}, &response); err != nil {
+		return 0, fmt.Errorf("failed to describe Ollama model %s: %w", model, err)
+	}
+	// The key is prefixed with the architecture, e.g. "llama.context_length".
+	for key, value := range response.ModelInfo {
+		if n, ok := value.(float64); ok && strings.HasSuffix(key, ".context_length") {
+			return int(n), nil
+		}
+	}
+	return 0, nil
+}
+
+func call(cfg *config.Config, method, path string, requestBody, responseTarget interface{}) error {
+	var body io.Reader
+	if requestBody != nil {
+		data, err := json.Marshal(requestBody)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, strings.TrimSuffix(cfg.OllamaURL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if requestBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := getClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if err := json.Unmarshal(data, responseTarget); err != nil {
+		return fmt.Errorf("failed to decode Ollama response: %w", err)
+	}
+	return nil
+}
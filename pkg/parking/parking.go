@@ -0,0 +1,376 @@
+// Package parking pairs sightings from entry and exit cameras into parking
+// sessions and flags vehicles that stay longer than their site allows.
+package parking
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/instance"
+	"punkplod23/go-agent-ollama-slm/pkg/kvstore"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/sightings"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrUnknownSite is returned when querying a site that is not configured.
+var ErrUnknownSite = errors.New("unknown parking site")
+
+// Site is a car park with its entry and exit cameras and stay limits.
+type Site struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name,omitempty"`
+	EntryCameras    []string `json:"entry_cameras"`
+	ExitCameras     []string `json:"exit_cameras"`
+	MaxStayMins     int      `json:"max_stay_minutes"`
+	GracePeriodMins int      `json:"grace_period_minutes,omitempty"`
+}
+
+// Allowed returns the longest stay before a session counts as an overstay.
+func (s Site) Allowed() time.Duration {
+	return time.Duration(s.MaxStayMins+s.GracePeriodMins) * time.Minute
+}
+
+// LoadSites reads a JSON array of sites from path.
+func LoadSites(path string) ([]Site, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read parking sites: %w", err)
+	}
+	var sites []Site
+	if err := json.Unmarshal(data, &sites); err != nil {
+		return nil, fmt.Errorf("failed to parse parking sites %s: %w", path, err)
+	}
+
+	cameras := map[string]string{}
+	for i, s := range sites {
+		if s.ID == "" {
+			return nil, fmt.Errorf("parking site %d: id is required", i+1)
+		}
+		if s.MaxStayMins <= 0 {
+			return nil, fmt.Errorf("parking site %s: max_stay_minutes must be positive", s.ID)
+		}
+		if s.GracePeriodMins < 0 {
+			return nil, fmt.Errorf("parking site %s: grace_period_minutes cannot be negative", s.ID)
+		}
+		for _, cam := range append(append([]string{}, s.EntryCameras...), s.ExitCameras...) {
+			if other, ok := cameras[cam]; ok {
+				return nil, fmt.Errorf("parking site %s: camera %s is already used by site %s", s.ID, cam, other)
+			}
+			cameras[cam] = s.ID
+		}
+	}
+	return sites, nil
+}
+
+// Session is a vehicle's stay at a site. ExitAt is nil while the vehicle is
+// still parked, and for an abandoned session: one whose exit was never read,
+// closed when the vehicle entered again at SupersededAt.
+type Session struct {
+	ID              string     `json:"id"`
+	SiteID          string     `json:"site_id"`
+	Plate           string     `json:"plate"`
+	EntryAt         time.Time  `json:"entry_at"`
+	ExitAt          *time.Time `json:"exit_at,omitempty"`
+	EntrySightingID string     `json:"entry_sighting_id"`
+	ExitSightingID  string     `json:"exit_sighting_id,omitempty"`
+	Abandoned       bool       `json:"abandoned,omitempty"`
+	SupersededAt    *time.Time `json:"superseded_at,omitempty"`
+}
+
+// SessionStatus is a session with its duration and overstay worked out.
+type SessionStatus struct {
+	Session
+	Open            bool  `json:"open"`
+	DurationSeconds int64 `json:"duration_seconds"`
+	Overstay        bool  `json:"overstay"`
+	OverstaySeconds int64 `json:"overstay_seconds,omitempty"`
+}
+
+// Status evaluates the session against its site's limits. Open sessions are
+// measured up to now. An abandoned session has no known exit, so it reports
+// neither a duration nor an overstay.
+func (s Session) Status(site Site, now time.Time) SessionStatus {
+	if s.Abandoned {
+		return SessionStatus{Session: s}
+	}
+	end := now
+	if s.ExitAt != nil {
+		end = *s.ExitAt
+	}
+	duration := end.Sub(s.EntryAt)
+	if duration < 0 {
+		duration = 0
+	}
+
+	status := SessionStatus{Session: s, Open: s.ExitAt == nil, DurationSeconds: int64(duration.Seconds())}
+	if over := duration - site.Allowed(); over > 0 {
+		status.Overstay = true
+		status.OverstaySeconds = int64(over.Seconds())
+	}
+	return status
+}
+
+// Session states a Query can select.
+const (
+	StatusOpen   = "open"
+	StatusClosed = "closed"
+)
+
+// Query filters sessions. Zero values match everything.
+type Query struct {
+	SiteID       string
+	Status       string // StatusOpen, StatusClosed or empty for both
+	OverstayOnly bool
+}
+
+// Tracker turns sightings into sessions.
+type Tracker struct {
+	db    *kvstore.Store
+	sites map[string]Site
+	// roles maps a camera ID to its site and whether it watches the entrance.
+	roles map[string]cameraRole
+	// mu serialises opening and closing sessions.
+	mu sync.Mutex
+}
+
+type cameraRole struct {
+	site  string
+	entry bool
+}
+
+const (
+	sessionPrefix = "session/"
+	openPrefix    = "open/" // open/{site}/{plate} -> session ID
+)
+
+func openKey(site, plate string) string { return openPrefix + site + "/" + plate }
+
+// Open opens the session store at path for the given sites; an empty path
+// keeps sessions in memory.
+func Open(path string, sites []Site) (*Tracker, error) {
+	db, err := kvstore.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parking store: %w", err)
+	}
+	t := &Tracker{db: db, sites: map[string]Site{}, roles: map[string]cameraRole{}}
+	for _, s := range sites {
+		t.sites[s.ID] = s
+		for _, cam := range s.EntryCameras {
+			t.roles[cam] = cameraRole{site: s.ID, entry: true}
+		}
+		for _, cam := range s.ExitCameras {
+			t.roles[cam] = cameraRole{site: s.ID}
+		}
+	}
+	return t, nil
+}
+
+// Sites returns the configured sites ordered by ID.
+func (t *Tracker) Sites() []Site {
+	sites := make([]Site, 0, len(t.sites))
+	for _, s := range t.sites {
+		sites = append(sites, s)
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].ID < sites[j].ID })
+	return sites
+}
+
+// cameraFor returns the camera a sighting was taken by, preferring the
+// capture metadata over the request's source ID.
+func cameraFor(s sightings.Sighting) string {
+	if s.Capture != nil && s.Capture.CameraID != "" {
+		return s.Capture.CameraID
+	}
+	return s.SourceID
+}
+
+// seenAt prefers the camera's capture time over the time the read was stored.
+func seenAt(s sightings.Sighting) time.Time {
+	if s.CapturedAt != nil {
+		return *s.CapturedAt
+	}
+	return s.Timestamp
+}
+
+// Observe applies a sighting. An entry camera opens a session unless the
+// plate already has one open at that site, so repeated reads of the same car
+// are harmless. If the open session started longer ago than the site allows,
+// its exit was missed: it is marked abandoned and a new session opens. An
+// exit camera closes the open session; an exit with no matching entry is
+// logged and ignored. Sightings from other cameras are skipped. The touched
+// session is returned, or nil.
+func (t *Tracker) Observe(s sightings.Sighting) (*Session, error) {
+	role, ok := t.roles[cameraFor(s)]
+	if !ok || s.Plate == "" {
+		return nil, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var openID string
+	hasOpen, err := t.db.Get(openKey(role.site, s.Plate), &openID)
+	if err != nil {
+		return nil, err
+	}
+
+	if role.entry {
+		if hasOpen {
+			superseded, err := t.supersede(openID, seenAt(s).UTC())
+			if err != nil || !superseded {
+				return nil, err
+			}
+		}
+		session := Session{
+			ID:              uuid.New().String(),
+			SiteID:          role.site,
+			Plate:           s.Plate,
+			EntryAt:         seenAt(s).UTC(),
+			EntrySightingID: s.ID,
+		}
+		if err := t.db.Put(sessionPrefix+session.ID, session); err != nil {
+			return nil, err
+		}
+		if err := t.db.Put(openKey(role.site, s.Plate), session.ID); err != nil {
+			return nil, err
+		}
+		return &session, nil
+	}
+
+	if !hasOpen {
+		logging.Warnf("parking: exit of %s at site %s without a recorded entry", s.Plate, role.site)
+		return nil, nil
+	}
+	var session Session
+	if _, err := t.db.Get(sessionPrefix+openID, &session); err != nil {
+		return nil, err
+	}
+	exitAt := seenAt(s).UTC()
+	session.ExitAt = &exitAt
+	session.ExitSightingID = s.ID
+	if err := t.db.Put(sessionPrefix+session.ID, session); err != nil {
+		return nil, err
+	}
+	if err := t.db.Delete(openKey(role.site, s.Plate)); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// supersede marks the open session abandoned when a new entry at enteredAt
+// comes after the longest stay its site allows, and reports whether it did.
+// Callers hold t.mu.
+func (t *Tracker) supersede(openID string, enteredAt time.Time) (bool, error) {
+	var session Session
+	if _, err := t.db.Get(sessionPrefix+openID, &session); err != nil {
+		return false, err
+	}
+	if enteredAt.Sub(session.EntryAt) <= t.sites[session.SiteID].Allowed() {
+		return false, nil
+	}
+	session.Abandoned = true
+	session.SupersededAt = &enteredAt
+	if err := t.db.Put(sessionPrefix+session.ID, session); err != nil {
+		return false, err
+	}
+	if err := t.db.Delete(openKey(session.SiteID, session.Plate)); err != nil {
+		return false, err
+	}
+	logging.Warnf("parking: %s entered site %s again with no exit read since %s; session %s marked abandoned",
+		session.Plate, session.SiteID, session.EntryAt.Format(time.RFC3339), session.ID)
+	return true, nil
+}
+
+// Sessions returns the sessions matching q, most recent entry first.
+func (t *Tracker) Sessions(q Query, now time.Time) ([]SessionStatus, error) {
+	if q.Status != "" && q.Status != StatusOpen && q.Status != StatusClosed {
+		return nil, fmt.Errorf("invalid status %q: use open or closed", q.Status)
+	}
+	if q.SiteID != "" {
+		if _, ok := t.sites[q.SiteID]; !ok {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownSite, q.SiteID)
+		}
+	}
+
+	results := []SessionStatus{}
+	var decodeErr error
+	t.db.Scan(sessionPrefix, func(_ string, v json.RawMessage) bool {
+		var s Session
+		if decodeErr = json.Unmarshal(v, &s); decodeErr != nil {
+			return false
+		}
+		if q.SiteID != "" && s.SiteID != q.SiteID {
+			return true
+		}
+		open := s.ExitAt == nil && !s.Abandoned
+		if (q.Status == StatusOpen && !open) || (q.Status == StatusClosed && open) {
+			return true
+		}
+		site, ok := t.sites[s.SiteID]
+		if !ok {
+			return true // site removed from the configuration
+		}
+		status := s.Status(site, now)
+		if q.OverstayOnly && !status.Overstay {
+			return true
+		}
+		results = append(results, status)
+		return true
+	})
+	sort.Slice(results, func(i, j int) bool { return results[i].EntryAt.After(results[j].EntryAt) })
+	return results, decodeErr
+}
+
+// --- Process-wide tracker ---
+
+var defaultTracker = instance.New(func() (*Tracker, error) { return Open("", nil) })
+
+// Configure loads the sites from PARKINGSITESPATH and opens the session store.
+// Without a sites file no cameras are tracked.
+func Configure(cfg *config.Config) error {
+	var sites []Site
+	if cfg.ParkingSitesPath != "" {
+		var err error
+		if sites, err = LoadSites(cfg.ParkingSitesPath); err != nil {
+			return err
+		}
+	}
+	tracker, err := Open(cfg.ParkingStorePath, sites)
+	if err != nil {
+		return err
+	}
+	defaultTracker.Set(tracker)
+	return nil
+}
+
+// Default returns the configured tracker, or an empty in-memory one if
+// Configure has not run.
+func Default() *Tracker {
+	return defaultTracker.Get()
+}
+
+// Track applies newly recorded sightings to the default tracker. Failures are
+// logged so parking problems never fail an ALPR request.
+func Track(recorded []sightings.Sighting) {
+	tracker := Default()
+	for _, s := range recorded {
+		session, err := tracker.Observe(s)
+		if err != nil {
+			logging.Errorf("parking: failed to track %s: %v", s.Plate, err)
+			continue
+		}
+		if session != nil && session.ExitAt != nil {
+			status := session.Status(tracker.sites[session.SiteID], time.Now())
+			if status.Overstay {
+				logging.Warnf("🅿️ OVERSTAY: %s at %s by %s", session.Plate, session.SiteID, time.Duration(status.OverstaySeconds)*time.Second)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package parking_test
+
+import (
+	"punkplod23/go-agent-ollama-slm/pkg/parking"
+	"punkplod23/go-agent-ollama-slm/pkg/sightings"
+	"testing"
+	"time"
+)
+
+func TestEntryAfterMissedExitAbandonsSession(t *testing.T) {
+	site := parking.Site{ID: "north", EntryCameras: []string{"in"}, ExitCameras: []string{"out"}, MaxStayMins: 60, GracePeriodMins: 10}
+	tracker, err := parking.Open("", []parking.Site{site})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	enter := func(at time.Time) *parking.Session {
+		t.Helper()
+		session, err := tracker.Observe(sightings.Sighting{ID: at.String(), Plate: "AB12CDE", Timestamp: at, SourceID: "in"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	first := enter(start)
+	if first == nil {
+		t.Fatal("entry opened no session")
+	}
+	if repeat := enter(start.Add(5 * time.Minute)); repeat != nil {
+		t.Errorf("repeat read within the limit opened session %+v", repeat)
+	}
+	second := enter(start.Add(3 * time.Hour))
+	if second == nil || second.ID == first.ID {
+		t.Fatalf("entry after the limit: got %+v, want a new session", second)
+	}
+
+	now := start.Add(3*time.Hour + time.Minute)
+	statuses, err := tracker.Sessions(parking.Query{SiteID: "north"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("got %d sessions, want 2", len(statuses))
+	}
+	for _, s := range statuses {
+		switch s.ID {
+		case first.ID:
+			if !s.Abandoned || s.Open || s.Overstay {
+				t.Errorf("missed exit: got %+v, want abandoned, closed and no overstay", s)
+			}
+		case second.ID:
+			if !s.Open || s.Abandoned {
+				t.Errorf("new entry: got %+v, want open", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Package ratelimit limits how often each client may call the API, using a
+// token bucket per client IP. The limits are process-wide and can be
+// changed while running, so RATELIMITRPS and RATELIMITBURST take effect on
+// reload.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxClients bounds the bucket table; idle full buckets are dropped first.
+const maxClients = 10000
+
+// Limiter hands out tokens per client key.
+type Limiter struct {
+	rate  float64 // tokens per second; 0 disables limiting
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter returns a limiter allowing rate requests per second per client
+// with bursts of up to burst. A rate of 0 allows everything.
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}}
+}
+
+// Allow takes a token for key. When none is left it returns false and how
+// long until the next one.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	if l == nil || l.rate <= 0 {
+		return true, 0
+	}
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxClients {
+			l.prune(now)
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops buckets that have refilled, which behave the same as new ones.
+func (l *Limiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+var (
+	current  atomic.Pointer[Limiter]
+	rejected atomic.Int64
+)
+
+// Configure applies RATELIMITRPS and RATELIMITBURST. Client buckets start
+// full again whenever the limits change.
+func Configure(cfg *config.Config) error {
+	old := current.Load()
+	if old != nil && old.rate == cfg.RateLimitRPS && old.burst == float64(max(cfg.RateLimitBurst, 1)) {
+		return nil
+	}
+	current.Store(NewLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst))
+	if cfg.RateLimitRPS > 0 {
+		logging.Infof("🚦 Rate limit: %g requests/s per client, burst %d", cfg.RateLimitRPS, cfg.RateLimitBurst)
+	}
+	return nil
+}
+
+// Rejected returns how many requests have been refused.
+func Rejected() int64 { return rejected.Load() }
+
+// exempt paths are probes that must keep answering under load.
+var exempt = map[string]bool{"/healthz": true, "/metrics": true}
+
+// Middleware refuses requests over the configured limit with 429.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ok, wait := current.Load().Allow(clientIP(r))
+		if !ok {
+			rejected.Add(1)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP keys requests by the connecting address. X-Forwarded-For is not
+// trusted, since any client could set it to dodge the limit.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,355 @@
+// Package resilience wraps upstream HTTP clients with retries, jittered
+// exponential backoff and a circuit breaker per dependency. Breaker state is
+// kept in a process-wide registry so it can be reported by health and
+// metrics endpoints.
+package resilience
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/safefetch"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the upstream while its breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Policy controls how requests to one dependency are retried and when its breaker trips.
+type Policy struct {
+	// MaxAttempts includes the first try; 1 disables retries.
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// RetryNonIdempotent allows POST and PATCH to be retried. Set it only for
+	// upstreams where repeating a request has no side effects (e.g. ALPR).
+	// Requests carrying an Idempotency-Key header are always retryable.
+	RetryNonIdempotent bool
+
+	// FailureThreshold consecutive failures open the breaker for OpenDuration.
+	FailureThreshold int
+	OpenDuration     time.Duration
+}
+
+// DefaultPolicy suits read-mostly upstreams.
+var DefaultPolicy = Policy{
+	MaxAttempts:      3,
+	BaseDelay:        200 * time.Millisecond,
+	MaxDelay:         2 * time.Second,
+	FailureThreshold: 5,
+	OpenDuration:     30 * time.Second,
+}
+
+// --- Circuit breaker ---
+
+// State of a circuit breaker.
+type State string
+
+const (
+	Closed   State = "closed"
+	Open     State = "open"
+	HalfOpen State = "half_open"
+)
+
+// Breaker is a consecutive-failure circuit breaker. While open it rejects
+// calls; after OpenDuration it lets a single trial call through (half-open)
+// and closes again if that succeeds.
+type Breaker struct {
+	name   string
+	policy Policy
+
+	mu          sync.Mutex
+	state       State
+	failures    int
+	openedAt    time.Time
+	trialActive bool
+
+	// Counters for metrics.
+	requests, retries, upstreamFailures, rejected, trips int64
+}
+
+// Snapshot is a point-in-time view of a breaker, used by health and metrics.
+type Snapshot struct {
+	Name                string     `json:"name"`
+	State               State      `json:"state"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	OpenedAt            *time.Time `json:"opened_at,omitempty"`
+	Requests            int64      `json:"requests"`
+	Retries             int64      `json:"retries"`
+	Failures            int64      `json:"failures"`
+	Rejected            int64      `json:"rejected"`
+	Trips               int64      `json:"trips"`
+}
+
+func (b *Breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open && time.Since(b.openedAt) >= b.policy.OpenDuration {
+		b.state = HalfOpen
+		b.trialActive = false
+	}
+	switch b.state {
+	case Open:
+		b.rejected++
+		return fmt.Errorf("%s: %w", b.name, ErrCircuitOpen)
+	case HalfOpen:
+		if b.trialActive {
+			b.rejected++
+			return fmt.Errorf("%s: %w (trial request in flight)", b.name, ErrCircuitOpen)
+		}
+		b.trialActive = true
+	}
+	return nil
+}
+
+func (b *Breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trialActive = false
+	if success {
+		b.failures = 0
+		b.state = Closed
+		return
+	}
+
+	b.upstreamFailures++
+	b.failures++
+	if b.state == HalfOpen || (b.policy.FailureThreshold > 0 && b.failures >= b.policy.FailureThreshold) {
+		if b.state != Open {
+			b.trips++
+		}
+		b.state = Open
+		b.openedAt = time.Now()
+	}
+}
+
+// release ends a call that neither succeeded nor failed, freeing the
+// half-open trial slot without changing the breaker's state.
+func (b *Breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialActive = false
+}
+
+// Policy returns the breaker's current retry and trip policy.
+func (b *Breaker) Policy() Policy {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.policy
+}
+
+// Snapshot returns the breaker's current state and counters.
+func (b *Breaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := Snapshot{
+		Name:                b.name,
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Requests:            b.requests,
+		Retries:             b.retries,
+		Failures:            b.upstreamFailures,
+		Rejected:            b.rejected,
+		Trips:               b.trips,
+	}
+	if b.state != Closed {
+		openedAt := b.openedAt
+		s.OpenedAt = &openedAt
+	}
+	return s
+}
+
+// --- Registry ---
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Breaker{}
+)
+
+// BreakerFor returns the named dependency's breaker, creating it with policy
+// on first use. Later calls update the policy in place.
+func BreakerFor(name string, policy Policy) *Breaker {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	b, ok := registry[name]
+	if !ok {
+		b = &Breaker{name: name, state: Closed}
+		registry[name] = b
+	}
+	b.mu.Lock()
+	b.policy = policy
+	b.mu.Unlock()
+	return b
+}
+
+// Snapshots reports every registered breaker, sorted by name.
+func Snapshots() []Snapshot {
+	registryMu.Lock()
+	breakers := make([]*Breaker, 0, len(registry))
+	for _, b := range registry {
+		breakers = append(breakers, b)
+	}
+	registryMu.Unlock()
+
+	out := make([]Snapshot, 0, len(breakers))
+	for _, b := range breakers {
+		out = append(out, b.Snapshot())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// --- Transport ---
+
+// Transport is an http.RoundTripper that retries transient failures and
+// trips a circuit breaker when an upstream keeps failing.
+type Transport struct {
+	Base    http.RoundTripper
+	Breaker *Breaker
+}
+
+// NewTransport wraps base (http.DefaultTransport when nil) with the named
+// dependency's retry policy and breaker.
+func NewTransport(name string, base http.RoundTripper, policy Policy) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Breaker: BreakerFor(name, policy)}
+}
+
+// NewClient is a convenience for an *http.Client using NewTransport.
+func NewClient(name string, base http.RoundTripper, policy Policy, timeout time.Duration) *http.Client {
+	return &http.Client{Transport: NewTransport(name, base, policy), Timeout: timeout}
+}
+
+// RoundTrip sends req, retrying transient failures. The breaker admits and
+// scores the request as a whole, so its retries count once.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := t.Breaker
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.roundTrip(req, b.Policy())
+	if refused(err) {
+		b.release()
+	} else {
+		// Any 5xx counts against the breaker, even the ones not worth retrying.
+		b.record(err == nil && resp.StatusCode < 500)
+	}
+	return resp, err
+}
+
+func (t *Transport) roundTrip(req *http.Request, policy Policy) (*http.Response, error) {
+	b := t.Breaker
+	attempts := policy.MaxAttempts
+	if attempts < 1 || !retryable(req, policy) {
+		attempts = 1
+	}
+
+	// Buffer the body once so it can be replayed on each attempt.
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody && attempts > 1 {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		b.mu.Lock()
+		b.requests++
+		if attempt > 1 {
+			b.retries++
+		}
+		b.mu.Unlock()
+
+		try := req
+		if body != nil {
+			try = req.Clone(req.Context())
+			try.Body = io.NopCloser(bytes.NewReader(body))
+			try.ContentLength = int64(len(body))
+		}
+
+		resp, err := t.Base.RoundTrip(try)
+		transient := (err != nil && !refused(err)) || (err == nil && transientStatus(resp.StatusCode))
+		if !transient || attempt == attempts {
+			return resp, err
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("upstream returned %s", resp.Status)
+			// Drain so the connection can be reused.
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		if err := sleep(req.Context(), backoff(policy, attempt)); err != nil {
+			return nil, err
+		}
+	}
+	return nil, lastErr
+}
+
+// refused is true for errors that say nothing about the upstream's health:
+// the egress policy or fetch guard refused the connection, or the caller gave
+// up. They are not retried and do not count for or against the breaker.
+func refused(err error) bool {
+	return errors.Is(err, egress.ErrDenied) || errors.Is(err, safefetch.ErrForbidden) || errors.Is(err, context.Canceled)
+}
+
+// retryable reports whether repeating req is safe under policy.
+func retryable(req *http.Request, policy Policy) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return policy.RetryNonIdempotent || req.Header.Get("Idempotency-Key") != ""
+}
+
+// transientStatus is true for responses worth retrying. Other 5xx responses
+// are returned at once but still count as failures for the breaker.
+func transientStatus(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns a full-jitter exponential delay for the given attempt.
+func backoff(policy Policy, attempt int) time.Duration {
+	d := policy.BaseDelay << (attempt - 1)
+	if policy.MaxDelay > 0 && (d > policy.MaxDelay || d <= 0) {
+		d = policy.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,65 @@
+package resilience_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/resilience"
+	"testing"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+var policy = resilience.Policy{MaxAttempts: 3, FailureThreshold: 2}
+
+func TestRetriesCountOnceAgainstBreaker(t *testing.T) {
+	calls := 0
+	transport := resilience.NewTransport(t.Name(), roundTripFunc(func(*http.Request) (*http.Response, error) {
+		calls++
+		return nil, errors.New("connection reset")
+	}), policy)
+
+	req, _ := http.NewRequest(http.MethodGet, "http://upstream.test", nil)
+	if _, err := transport.RoundTrip(req); err == nil {
+		t.Fatal("expected an error")
+	}
+	s := transport.Breaker.Snapshot()
+	if calls != 3 || s.Retries != 2 {
+		t.Errorf("calls = %d, retries = %d, want 3 and 2", calls, s.Retries)
+	}
+	if s.ConsecutiveFailures != 1 || s.State != resilience.Closed {
+		t.Errorf("breaker = %+v, want one failure and still closed", s)
+	}
+}
+
+func TestRefusedRequestsAreNotFailures(t *testing.T) {
+	for name, refusal := range map[string]error{
+		"egress denied": fmt.Errorf("dial: %w", egress.ErrDenied),
+		"cancelled":     context.Canceled,
+	} {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			transport := resilience.NewTransport(t.Name(), roundTripFunc(func(*http.Request) (*http.Response, error) {
+				calls++
+				return nil, refusal
+			}), policy)
+
+			req, _ := http.NewRequest(http.MethodGet, "http://upstream.test", nil)
+			for i := 0; i < 3; i++ {
+				if _, err := transport.RoundTrip(req); !errors.Is(err, refusal) {
+					t.Fatalf("got %v, want %v", err, refusal)
+				}
+			}
+			if calls != 3 {
+				t.Errorf("refused requests were retried: %d calls for 3 requests", calls)
+			}
+			if s := transport.Breaker.Snapshot(); s.Failures != 0 || s.State != resilience.Closed {
+				t.Errorf("breaker = %+v, want no failures", s)
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+// Package safefetch fetches URLs supplied by callers without letting them
+// reach the cluster network. Each connection resolves the host once, refuses
+// private, loopback, link-local and metadata addresses, and then dials the
+// address it checked, so a DNS answer cannot change between check and use.
+// Redirects are checked the same way, and responses are capped in size and time.
+//
+// Operator-configured upstreams use the egress package instead; its CIDR and
+// port rules also apply here, but its hostname allow-list does not, since
+// caller URLs name arbitrary public hosts.
+package safefetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrForbidden is returned for URLs or addresses the fetcher refuses.
+var ErrForbidden = errors.New("forbidden destination")
+
+// ErrTooLarge is returned when a response exceeds the size cap.
+var ErrTooLarge = errors.New("response too large")
+
+// privateRanges are never reachable through a caller-supplied URL. The IPv6
+// ranges that embed an IPv4 address (IPv4-compatible, NAT64, Teredo and 6to4)
+// are refused whole, since the address inside may be a private one.
+var privateRanges = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16",
+	"172.16.0.0/12", "192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/96", "::1/128", "64:ff9b::/96", "64:ff9b:1::/48", "2001::/32", "2002::/16",
+	"fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+// CheckIP reports why ip may not be fetched from, or nil if it may.
+func CheckIP(ip net.IP) error {
+	for _, n := range privateRanges {
+		if n.Contains(ip) {
+			return fmt.Errorf("%w: %s is a private or reserved address", ErrForbidden, ip)
+		}
+	}
+	if err := egress.Current().CheckIP(ip); err != nil {
+		return fmt.Errorf("%w: %v", ErrForbidden, err)
+	}
+	return nil
+}
+
+// ValidateURL checks a URL's scheme, host and port without resolving it.
+// Hosts given as IP literals are checked against the blocked ranges.
+func ValidateURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid URL: %v", ErrForbidden, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%w: scheme %q is not allowed", ErrForbidden, u.Scheme)
+	}
+	if u.User != nil {
+		return nil, fmt.Errorf("%w: URLs with credentials are not allowed", ErrForbidden)
+	}
+	host := u.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("%w: URL has no host", ErrForbidden)
+	}
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return nil, fmt.Errorf("%w: %s is a local name", ErrForbidden, host)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if err := CheckIP(ip); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// dialContext resolves the host once, checks every address and dials the
+// first one that passes. Nothing is dialled by name.
+func dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid address %q", ErrForbidden, addr)
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %q", ErrForbidden, port)
+	}
+	if err := egress.Current().CheckPort(portNum); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrForbidden, err)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+		for _, a := range addrs {
+			ips = append(ips, a.IP)
+		}
+	}
+
+	// Refuse the host outright if any address is forbidden, so a name that
+	// mixes public and private answers cannot be used to probe the network.
+	for _, ip := range ips {
+		if err := CheckIP(ip); err != nil {
+			return nil, fmt.Errorf("%s: %w", host, err)
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var lastErr error
+	for _, ip := range ips {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Limits bound a fetch.
+type Limits struct {
+	MaxBytes     int64
+	Timeout      time.Duration
+	MaxRedirects int
+}
+
+var (
+	mu     sync.RWMutex
+	limits = Limits{MaxBytes: 10 << 20, Timeout: 15 * time.Second, MaxRedirects: 3}
+)
+
+// Configure applies the fetch limits from the configuration.
+func Configure(cfg *config.Config) {
+	mu.Lock()
+	defer mu.Unlock()
+	limits = Limits{MaxBytes: cfg.FetchMaxBytes, Timeout: cfg.FetchTimeout, MaxRedirects: cfg.FetchMaxRedirects}
+}
+
+// CurrentLimits returns the configured limits.
+func CurrentLimits() Limits {
+	mu.RLock()
+	defer mu.RUnlock()
+	return limits
+}
+
+// NewTransport returns a transport that only dials checked public addresses.
+func NewTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = dialContext
+	return t
+}
+
+var sharedTransport = NewTransport()
+
+// Transport returns the shared transport for caller-supplied URLs.
+func Transport() *http.Transport { return sharedTransport }
+
+// CheckRedirect validates each redirect target and caps the number of hops.
+// Assign it to http.Client.CheckRedirect.
+func CheckRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > CurrentLimits().MaxRedirects {
+		return fmt.Errorf("%w: too many redirects", ErrForbidden)
+	}
+	_, err := ValidateURL(req.URL.String())
+	return err
+}
+
+// Client returns an HTTP client for caller-supplied URLs.
+func Client() *http.Client {
+	return &http.Client{Transport: Transport(), CheckRedirect: CheckRedirect, Timeout: CurrentLimits().Timeout}
+}
+
+// Response is a fetched document.
+type Response struct {
+	Body        []byte
+	ContentType string
+	FinalURL    string
+}
+
+// Get fetches a caller-supplied URL. maxBytes overrides the configured size
+// cap when positive.
+func Get(raw string, maxBytes int64) (*Response, error) {
+	if _, err := ValidateURL(raw); err != nil {
+		return nil, err
+	}
+	if maxBytes <= 0 {
+		maxBytes = CurrentLimits().MaxBytes
+	}
+
+	resp, err := Client().Get(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", raw, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned status %d", raw, resp.StatusCode)
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, fmt.Errorf("%w: %s is %d bytes, limit is %d", ErrTooLarge, raw, resp.ContentLength, maxBytes)
+	}
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", raw, err)
+	}
+	if n > maxBytes {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrTooLarge, raw, maxBytes)
+	}
+	return &Response{Body: buf.Bytes(), ContentType: resp.Header.Get("Content-Type"), FinalURL: resp.Request.URL.String()}, nil
+}
@@ -0,0 +1,306 @@
+// Package sightings keeps a history of successful ALPR reads so callers can
+// ask when and where a vehicle was last seen.
+package sightings
+
+import (
+	"encoding/json"
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/instance"
+	"punkplod23/go-agent-ollama-slm/pkg/kvstore"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	keyPrefix = "sighting/"
+	// plateKeyPrefix indexes sightings by plate: plate/<plate>/<time>/<id>
+	// mirrors sighting/<time>/<id>, so a plate's sightings are found without
+	// decoding the whole store.
+	plateKeyPrefix = "plate/"
+	// keyTimeLayout sorts lexically in time order.
+	keyTimeLayout = "20060102T150405.000000000Z"
+
+	// DefaultLimit and MaxLimit bound a page of query results.
+	DefaultLimit = 50
+	MaxLimit     = 1000
+)
+
+// Sighting is a single plate read.
+type Sighting struct {
+	ID          string            `json:"id"`
+	Plate       string            `json:"plate"`
+	ReadText    string            `json:"read_text"`
+	Confidence  float64           `json:"confidence"`
+	Timestamp   time.Time         `json:"timestamp"`             // when the read was received
+	CapturedAt  *time.Time        `json:"captured_at,omitempty"` // when the camera took it, from the request or EXIF
+	SourceID    string            `json:"source_id,omitempty"`
+	BoundingBox tools.BoundingBox `json:"bounding_box"`
+	ImageHash   string            `json:"image_hash"` // hex SHA-256 of the uploaded image
+
+	Capture *tools.CaptureMetadata `json:"capture,omitempty"`
+}
+
+// Query filters sightings. Zero values match everything.
+type Query struct {
+	Plate    string
+	SourceID string
+	CameraID string
+	From     time.Time
+	To       time.Time
+	Limit    int
+	Offset   int
+}
+
+// Page is one page of query results, newest first.
+type Page struct {
+	Sightings []Sighting `json:"sightings"`
+	Total     int        `json:"total"`
+	Limit     int        `json:"limit"`
+	Offset    int        `json:"offset"`
+}
+
+// Store persists sightings in an embedded key-value store.
+type Store struct {
+	db *kvstore.Store
+}
+
+// Open opens the sighting store at path; an empty path keeps it in memory.
+func Open(path string) (*Store, error) {
+	db, err := kvstore.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sighting store: %w", err)
+	}
+	s := &Store{db: db}
+	if err := s.indexPlates(); err != nil {
+		return nil, fmt.Errorf("failed to index sighting store: %w", err)
+	}
+	return s, nil
+}
+
+func key(s Sighting) string {
+	return keyPrefix + s.Timestamp.UTC().Format(keyTimeLayout) + "/" + s.ID
+}
+
+func plateKey(s Sighting) string {
+	return plateKeyPrefix + s.Plate + "/" + strings.TrimPrefix(key(s), keyPrefix)
+}
+
+// indexPlates adds the plate index to a store written before it existed.
+func (s *Store) indexPlates() error {
+	if len(s.db.Keys(plateKeyPrefix)) == len(s.db.Keys(keyPrefix)) {
+		return nil
+	}
+	var plateKeys []string
+	var decodeErr error
+	s.db.Scan(keyPrefix, func(_ string, value json.RawMessage) bool {
+		var sighting Sighting
+		if decodeErr = json.Unmarshal(value, &sighting); decodeErr != nil {
+			return false
+		}
+		plateKeys = append(plateKeys, plateKey(sighting))
+		return true
+	})
+	if decodeErr != nil {
+		return decodeErr
+	}
+	for _, k := range plateKeys {
+		if err := s.db.Put(k, struct{}{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add stores a sighting, filling in its ID and timestamp when missing.
+func (s *Store) Add(sighting Sighting) (*Sighting, error) {
+	if sighting.ID == "" {
+		sighting.ID = uuid.New().String()
+	}
+	if sighting.Timestamp.IsZero() {
+		sighting.Timestamp = time.Now()
+	}
+	sighting.Timestamp = sighting.Timestamp.UTC()
+	sighting.Plate = tools.NormalisePlate(sighting.Plate)
+	if err := s.db.Put(key(sighting), sighting); err != nil {
+		return nil, err
+	}
+	if err := s.db.Put(plateKey(sighting), struct{}{}); err != nil {
+		return nil, err
+	}
+	return &sighting, nil
+}
+
+// RecordResults stores a sighting for every ALPR result with readable text.
+func (s *Store) RecordResults(results []tools.ALPRResult, sourceID string, capture *tools.CaptureMetadata, image []byte, at time.Time) ([]Sighting, error) {
+	hash := tools.ImageHash(image)
+	var capturedAt *time.Time
+	if capture != nil {
+		capturedAt = capture.CapturedAt
+	}
+	var recorded []Sighting
+	for _, result := range results {
+		plate := tools.NormalisePlate(result.OCR.Text)
+		if plate == "" {
+			continue
+		}
+		sighting, err := s.Add(Sighting{
+			Plate:       plate,
+			ReadText:    strings.TrimSpace(result.OCR.Text),
+			Confidence:  result.OCR.Confidence,
+			Timestamp:   at,
+			SourceID:    sourceID,
+			BoundingBox: result.Detection.BoundingBox,
+			ImageHash:   hash,
+			Capture:     capture,
+			CapturedAt:  capturedAt,
+		})
+		if err != nil {
+			return recorded, err
+		}
+		recorded = append(recorded, *sighting)
+	}
+	return recorded, nil
+}
+
+// Find returns sightings matching q, newest first.
+func (s *Store) Find(q Query) (*Page, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	plate := tools.NormalisePlate(q.Plate)
+
+	// A plate query walks that plate's index; both key forms end in
+	// <time>/<id>, so the time range is applied before decoding.
+	prefix := keyPrefix
+	if plate != "" {
+		prefix = plateKeyPrefix + plate + "/"
+	}
+	var from, to string
+	if !q.From.IsZero() {
+		from = q.From.UTC().Format(keyTimeLayout)
+	}
+	if !q.To.IsZero() {
+		to = q.To.UTC().Format(keyTimeLayout)
+	}
+
+	page := &Page{Sightings: []Sighting{}, Limit: q.Limit, Offset: q.Offset}
+	keys := s.db.Keys(prefix)
+
+	for i := len(keys) - 1; i >= 0; i-- {
+		suffix := strings.TrimPrefix(keys[i], prefix)
+		at, _, _ := strings.Cut(suffix, "/")
+		if to != "" && at > to {
+			continue
+		}
+		if from != "" && at < from {
+			break
+		}
+
+		var sighting Sighting
+		found, err := s.db.Get(keyPrefix+suffix, &sighting)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			continue
+		}
+		if q.SourceID != "" && sighting.SourceID != q.SourceID {
+			continue
+		}
+		if q.CameraID != "" && (sighting.Capture == nil || sighting.Capture.CameraID != q.CameraID) {
+			continue
+		}
+
+		if page.Total >= q.Offset && len(page.Sightings) < q.Limit {
+			page.Sightings = append(page.Sightings, sighting)
+		}
+		page.Total++
+	}
+	return page, nil
+}
+
+// Latest returns the most recent sighting of a plate, or nil if it has never been seen.
+func (s *Store) Latest(plate string) (*Sighting, error) {
+	page, err := s.Find(Query{Plate: plate, Limit: 1})
+	if err != nil || len(page.Sightings) == 0 {
+		return nil, err
+	}
+	return &page.Sightings[0], nil
+}
+
+// Prune deletes sightings older than before and returns how many were removed.
+func (s *Store) Prune(before time.Time) (int, error) {
+	cutoff := keyPrefix + before.UTC().Format(keyTimeLayout)
+	removed := 0
+	for _, k := range s.db.Keys(keyPrefix) {
+		if k >= cutoff {
+			break
+		}
+		var sighting Sighting
+		if _, err := s.db.Get(k, &sighting); err != nil {
+			return removed, err
+		}
+		if err := s.db.Delete(plateKey(sighting)); err != nil {
+			return removed, err
+		}
+		if err := s.db.Delete(k); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// --- Process-wide store ---
+
+var defaultStore = instance.New(func() (*Store, error) { return Open("") })
+
+// Configure opens the sighting store selected by the configuration and, unless
+// SIGHTINGRETENTION is 0, prunes old sightings every hour.
+func Configure(cfg *config.Config) error {
+	store, err := Open(cfg.SightingStorePath)
+	if err != nil {
+		return err
+	}
+	defaultStore.Set(store)
+
+	if cfg.SightingRetention > 0 {
+		go func() {
+			for ; ; time.Sleep(time.Hour) {
+				if n, err := store.Prune(time.Now().Add(-cfg.SightingRetention)); err != nil {
+					logging.Errorf("sightings: prune failed: %v", err)
+				} else if n > 0 {
+					logging.Infof("sightings: pruned %d sightings older than %s", n, cfg.SightingRetention)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Default returns the configured store, or an in-memory one if Configure has
+// not run.
+func Default() *Store {
+	return defaultStore.Get()
+}
+
+// Record stores the ALPR results in the default store, timestamped with the
+// time they were received. The camera's capture time, which the request or
+// EXIF supplies and the server cannot check, is kept separately in
+// CapturedAt. Failures are logged rather than returned so history
+// problems never fail an ALPR request.
+func Record(results []tools.ALPRResult, sourceID string, capture *tools.CaptureMetadata, image []byte) []Sighting {
+	recorded, err := Default().RecordResults(results, sourceID, capture, image, time.Now())
+	if err != nil {
+		logging.Errorf("sightings: failed to record: %v", err)
+	}
+	return recorded
+}
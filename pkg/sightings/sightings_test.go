@@ -0,0 +1,45 @@
+package sightings_test
+
+import (
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/pkg/sightings"
+	"testing"
+	"time"
+)
+
+func TestFindByPlate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sightings.jsonl")
+	store, err := sightings.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	for i, plate := range []string{"AB12CDE", "XY34ZZZ", "ab12 cde", "AB12CDE"} {
+		if _, err := store.Add(sightings.Sighting{Plate: plate, Timestamp: start.Add(time.Duration(i) * time.Minute)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := store.Find(sightings.Query{Plate: "AB12CDE", From: start.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || !page.Sightings[0].Timestamp.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("page = %+v, want the two reads from minute 2 on, newest first", page)
+	}
+
+	if n, err := store.Prune(start.Add(time.Minute)); err != nil || n != 1 {
+		t.Fatalf("Prune = %d, %v, want 1", n, err)
+	}
+	reopened, err := sightings.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := reopened.Latest("ab12cde")
+	if err != nil || latest == nil || !latest.Timestamp.Equal(start.Add(3*time.Minute)) {
+		t.Errorf("Latest = %+v, %v", latest, err)
+	}
+	if page, _ := reopened.Find(sightings.Query{Plate: "AB12CDE"}); page.Total != 2 {
+		t.Errorf("after pruning the first read: %d sightings, want 2", page.Total)
+	}
+}
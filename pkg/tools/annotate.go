@@ -0,0 +1,216 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// Annotation formats accepted by AnnotateImage.
+const (
+	AnnotationPNG  = "png"
+	AnnotationJPEG = "jpeg"
+)
+
+var (
+	boxColour    = color.RGBA{R: 255, G: 32, B: 32, A: 255}
+	labelColour  = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+	labelBgColor = color.RGBA{R: 200, G: 0, B: 0, A: 255}
+)
+
+// PlateCrop is a thumbnail of a single detected plate.
+type PlateCrop struct {
+	Text        string      `json:"text"`
+	Confidence  float64     `json:"confidence"`
+	BoundingBox BoundingBox `json:"bounding_box"`
+	Image       string      `json:"image"` // data URI
+}
+
+// Annotation holds the input image with detections drawn on it, plus plate crops.
+type Annotation struct {
+	Image  string      `json:"image"` // data URI
+	Crops  []PlateCrop `json:"plate_crops"`
+	Format string      `json:"format"`
+}
+
+// AnnotateImage draws each ALPR detection's bounding box and OCR text onto
+// the image and cuts out a thumbnail of every plate.
+func AnnotateImage(data []byte, results []ALPRResult, format string) (*Annotation, error) {
+	switch format = strings.ToLower(format); format {
+	case "":
+		format = AnnotationPNG
+	case "jpg":
+		format = AnnotationJPEG
+	}
+	if format != AnnotationPNG && format != AnnotationJPEG {
+		return nil, fmt.Errorf("unsupported annotation format %q: expected png or jpeg", format)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image for annotation: %w", err)
+	}
+
+	canvas := image.NewRGBA(src.Bounds())
+	draw.Draw(canvas, canvas.Bounds(), src, src.Bounds().Min, draw.Src)
+
+	annotation := &Annotation{Format: format}
+
+	for _, result := range results {
+		rect := boxRect(result.Detection.BoundingBox).Add(src.Bounds().Min).Intersect(src.Bounds())
+		if rect.Empty() {
+			continue
+		}
+
+		// Crop from the untouched source so thumbnails carry no overlay.
+		crop := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+		draw.Draw(crop, crop.Bounds(), src, rect.Min, draw.Src)
+		cropURI, err := encodeImage(crop, format)
+		if err != nil {
+			return nil, err
+		}
+		annotation.Crops = append(annotation.Crops, PlateCrop{
+			Text:        strings.TrimSpace(result.OCR.Text),
+			Confidence:  result.OCR.Confidence,
+			BoundingBox: result.Detection.BoundingBox,
+			Image:       cropURI,
+		})
+
+		drawBox(canvas, rect, 2)
+		label := fmt.Sprintf("%s %.0f%%", strings.TrimSpace(result.OCR.Text), result.OCR.Confidence*100)
+		drawLabel(canvas, rect, label)
+	}
+
+	annotation.Image, err = encodeImage(canvas, format)
+	if err != nil {
+		return nil, err
+	}
+	return annotation, nil
+}
+
+// boxRect converts an ALPR bounding box into a normalised image rectangle.
+func boxRect(b BoundingBox) image.Rectangle {
+	return image.Rect(b.X1, b.Y1, b.X2, b.Y2).Canon()
+}
+
+func encodeImage(img image.Image, format string) (string, error) {
+	var buf bytes.Buffer
+	mimeType := "image/png"
+	var err error
+	if format == AnnotationJPEG {
+		mimeType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to encode annotated image: %w", err)
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func drawBox(img *image.RGBA, r image.Rectangle, thickness int) {
+	fill := image.NewUniform(boxColour)
+	for t := 0; t < thickness; t++ {
+		edges := []image.Rectangle{
+			image.Rect(r.Min.X, r.Min.Y+t, r.Max.X, r.Min.Y+t+1),
+			image.Rect(r.Min.X, r.Max.Y-t-1, r.Max.X, r.Max.Y-t),
+			image.Rect(r.Min.X+t, r.Min.Y, r.Min.X+t+1, r.Max.Y),
+			image.Rect(r.Max.X-t-1, r.Min.Y, r.Max.X-t, r.Max.Y),
+		}
+		for _, e := range edges {
+			draw.Draw(img, e.Intersect(img.Bounds()), fill, image.Point{}, draw.Src)
+		}
+	}
+}
+
+// drawLabel renders text on a filled strip above the box, or inside it when
+// the box touches the top of the image.
+func drawLabel(img *image.RGBA, box image.Rectangle, text string) {
+	const scale = 2
+	text = strings.ToUpper(text)
+	width := len(text)*(glyphWidth+1)*scale + 2*scale
+	height := (glyphHeight + 2) * scale
+
+	origin := image.Pt(box.Min.X, box.Min.Y-height)
+	if origin.Y < img.Bounds().Min.Y {
+		origin.Y = box.Min.Y
+	}
+	strip := image.Rect(origin.X, origin.Y, origin.X+width, origin.Y+height).Intersect(img.Bounds())
+	draw.Draw(img, strip, image.NewUniform(labelBgColor), image.Point{}, draw.Src)
+
+	x := origin.X + scale
+	y := origin.Y + scale
+	for _, ch := range text {
+		glyph, ok := glyphs[ch]
+		if !ok {
+			glyph = glyphs['?']
+		}
+		for row, bits := range glyph {
+			for col := 0; col < glyphWidth; col++ {
+				if bits[col] != '#' {
+					continue
+				}
+				px := image.Rect(x+col*scale, y+row*scale, x+(col+1)*scale, y+(row+1)*scale)
+				draw.Draw(img, px.Intersect(img.Bounds()), image.NewUniform(labelColour), image.Point{}, draw.Src)
+			}
+		}
+		x += (glyphWidth + 1) * scale
+	}
+}
+
+// A minimal 5x7 bitmap font covering the characters found on plates and labels.
+const (
+	glyphWidth  = 5
+	glyphHeight = 7
+)
+
+var glyphs = map[rune][glyphHeight]string{
+	' ': {".....", ".....", ".....", ".....", ".....", ".....", "....."},
+	'-': {".....", ".....", ".....", "#####", ".....", ".....", "....."},
+	'.': {".....", ".....", ".....", ".....", ".....", ".##..", ".##.."},
+	'%': {"##...", "##..#", "...#.", "..#..", ".#...", "#..##", "...##"},
+	'?': {".###.", "#...#", "....#", "...#.", "..#..", ".....", "..#.."},
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+	'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B': {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C': {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D': {"###..", "#..#.", "#...#", "#...#", "#...#", "#..#.", "###.."},
+	'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G': {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I': {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J': {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K': {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M': {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N': {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O': {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P': {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q': {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R': {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S': {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V': {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W': {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X': {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z': {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+}
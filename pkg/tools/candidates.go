@@ -0,0 +1,184 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"sort"
+	"strings"
+)
+
+// MaxPlateCandidates caps how many alternative plates are tried against DVSA
+// after the OCR text itself has failed.
+const MaxPlateCandidates = 8
+
+// ocrConfusions maps each character to the character the OCR engine most
+// commonly mistakes it for. Every pair crosses the letter/digit boundary, so
+// the plate format decides which side of the pair is valid at each position.
+var ocrConfusions = map[rune]rune{
+	'0': 'O', 'O': '0',
+	'1': 'I', 'I': '1',
+	'5': 'S', 'S': '5',
+	'8': 'B', 'B': '8',
+	'2': 'Z', 'Z': '2',
+}
+
+// ukPlateFormats lists the UK registration layouts in ranked order, using
+// 'L' for a letter and 'N' for a digit. Current-style plates come first as
+// they are by far the most common on the road.
+var ukPlateFormats = []string{
+	// Current (2001 onwards): AB12CDE
+	"LLNNLLL",
+	// Prefix (1983-2001): A123BCD
+	"LNNNLLL", "LNNLLL", "LNLLL",
+	// Suffix (1963-1983): ABC123D
+	"LLLNNNL", "LLLNNL", "LLLNL",
+	// Northern Ireland: ABC1234
+	"LLLNNNN", "LLLNNN", "LLNNNN",
+	// Dateless
+	"LNNNN", "LLNNN", "LLLNN", "NNNNL", "NNNLL", "NNLLL",
+}
+
+// PlateCandidate is an alternative reading of an OCR result.
+type PlateCandidate struct {
+	Registration  string `json:"registration"`
+	Format        string `json:"format"`
+	Substitutions int    `json:"substitutions"`
+}
+
+// CandidateAttempt records the outcome of a single DVSA lookup.
+type CandidateAttempt struct {
+	Registration  string `json:"registration"`
+	Substitutions int    `json:"substitutions"`
+	Result        string `json:"result"` // "verified", "not_found" or "error"
+	CacheStatus   string `json:"cache_status"`
+}
+
+// PlateVerification is the outcome of checking an OCR read against DVSA.
+type PlateVerification struct {
+	OCRText        string             `json:"ocr_text"`
+	RegistrationID string             `json:"registration_id"`
+	Candidate      bool               `json:"candidate"` // true when an OCR correction verified rather than the raw read
+	Attempts       []CandidateAttempt `json:"attempts"`
+	Vehicle        *VehicleResponse   `json:"-"`
+}
+
+// NormalisePlate upper-cases a registration and strips spaces and dashes.
+func NormalisePlate(plate string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(plate) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// PlateCandidates returns alternative readings of an OCR result produced by
+// swapping commonly confused characters so that the plate fits a known UK
+// format. The original reading is never included. Candidates are ranked by
+// the number of substitutions and then by format order.
+func PlateCandidates(ocrText string) []PlateCandidate {
+	plate := NormalisePlate(ocrText)
+
+	seen := map[string]bool{plate: true}
+	var candidates []PlateCandidate
+
+	for _, format := range ukPlateFormats {
+		if len(format) != len(plate) {
+			continue
+		}
+
+		candidate, substitutions, ok := fitFormat(plate, format)
+		if !ok || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		candidates = append(candidates, PlateCandidate{
+			Registration:  candidate,
+			Format:        format,
+			Substitutions: substitutions,
+		})
+	}
+
+	// Stable sort keeps the format ranking for equal substitution counts.
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Substitutions < candidates[j].Substitutions
+	})
+
+	if len(candidates) > MaxPlateCandidates {
+		candidates = candidates[:MaxPlateCandidates]
+	}
+	return candidates
+}
+
+// fitFormat coerces plate into format, substituting confusable characters
+// where the character class is wrong. It fails if any position cannot be fixed.
+func fitFormat(plate, format string) (string, int, bool) {
+	out := []rune(plate)
+	substitutions := 0
+
+	for i, want := range format {
+		r := out[i]
+		if classOf(r) == want {
+			continue
+		}
+		alt, ok := ocrConfusions[r]
+		if !ok || classOf(alt) != want {
+			return "", 0, false
+		}
+		out[i] = alt
+		substitutions++
+	}
+	return string(out), substitutions, true
+}
+
+func classOf(r rune) rune {
+	if r >= '0' && r <= '9' {
+		return 'N'
+	}
+	return 'L'
+}
+
+// VerifyRegistration looks up an OCR read in DVSA. If DVSA has no record of
+// the plate as read, OCR-confusion candidates are tried in ranked order and
+// the first one DVSA recognises is reported.
+func VerifyRegistration(ocrText string, cfg *config.Config) (*PlateVerification, error) {
+	plate := NormalisePlate(ocrText)
+	if plate == "" {
+		return nil, fmt.Errorf("no registration to verify")
+	}
+
+	verification := &PlateVerification{OCRText: ocrText}
+
+	tries := []PlateCandidate{{Registration: plate}}
+	tries = append(tries, PlateCandidates(plate)...)
+
+	for i, try := range tries {
+		vehicle, cacheStatus, err := LookupVehicleWithStatus(try.Registration, cfg)
+		attempt := CandidateAttempt{Registration: try.Registration, Substitutions: try.Substitutions, CacheStatus: string(cacheStatus)}
+
+		switch {
+		case err == nil:
+			attempt.Result = "verified"
+			verification.Attempts = append(verification.Attempts, attempt)
+			verification.RegistrationID = try.Registration
+			verification.Candidate = i > 0
+			verification.Vehicle = vehicle
+			logging.Debugf("✅ Verified registration %s (OCR read %s, %d substitutions)", try.Registration, plate, try.Substitutions)
+			return verification, nil
+		case errors.Is(err, ErrVehicleNotFound):
+			attempt.Result = "not_found"
+			verification.Attempts = append(verification.Attempts, attempt)
+		default:
+			// Anything other than a 404 means DVSA itself is unhealthy;
+			// trying more candidates would only repeat the failure.
+			attempt.Result = "error"
+			verification.Attempts = append(verification.Attempts, attempt)
+			return verification, err
+		}
+	}
+
+	return verification, fmt.Errorf("no candidate for %s verified after %d attempts: %w", plate, len(verification.Attempts), ErrVehicleNotFound)
+}
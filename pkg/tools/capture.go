@@ -0,0 +1,101 @@
+package tools
+
+import (
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"strings"
+	"time"
+)
+
+// Where a capture time or position came from.
+const (
+	SourceRequest = "request"
+	SourceEXIF    = "exif"
+)
+
+// CaptureMetadata describes where and when an image was taken. Fields sent
+// with the request take precedence over values read from the image's EXIF.
+type CaptureMetadata struct {
+	CameraID       string     `json:"camera_id,omitempty"`
+	Lane           string     `json:"lane,omitempty"`
+	Direction      string     `json:"direction,omitempty"` // e.g. "in", "out", "northbound"
+	Latitude       *float64   `json:"latitude,omitempty"`
+	Longitude      *float64   `json:"longitude,omitempty"`
+	CapturedAt     *time.Time `json:"captured_at,omitempty"`
+	LocationSource string     `json:"location_source,omitempty"`
+	TimeSource     string     `json:"time_source,omitempty"`
+}
+
+// Validate checks that any coordinates are complete and in range.
+func (m *CaptureMetadata) Validate() error {
+	if (m.Latitude == nil) != (m.Longitude == nil) {
+		return fmt.Errorf("latitude and longitude must be given together")
+	}
+	if m.Latitude == nil {
+		return nil
+	}
+	return validPosition(*m.Latitude, *m.Longitude)
+}
+
+// validPosition rejects coordinates outside ±90 latitude and ±180 longitude,
+// including NaN.
+func validPosition(lat, lon float64) error {
+	if !(lat >= -90 && lat <= 90) {
+		return fmt.Errorf("latitude %v out of range", lat)
+	}
+	if !(lon >= -180 && lon <= 180) {
+		return fmt.Errorf("longitude %v out of range", lon)
+	}
+	return nil
+}
+
+// IsZero reports whether no metadata is set.
+func (m *CaptureMetadata) IsZero() bool {
+	return m == nil || *m == (CaptureMetadata{})
+}
+
+// ResolveCapture validates the request metadata and fills in the capture time
+// and position from the image's EXIF where the request left them out. It takes
+// the image PrepareImage has already validated, so EXIF is only parsed from a
+// supported image within the size limits. EXIF problems are logged rather than
+// failing the request.
+func ResolveCapture(m CaptureMetadata, image *PreparedImage) (*CaptureMetadata, error) {
+	m.CameraID = strings.TrimSpace(m.CameraID)
+	m.Lane = strings.TrimSpace(m.Lane)
+	m.Direction = strings.TrimSpace(m.Direction)
+	m.LocationSource, m.TimeSource = "", ""
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if m.Latitude != nil {
+		m.LocationSource = SourceRequest
+	}
+	if m.CapturedAt != nil {
+		t := m.CapturedAt.UTC()
+		m.CapturedAt, m.TimeSource = &t, SourceRequest
+	}
+
+	if m.Latitude == nil || m.CapturedAt == nil {
+		exif, err := ReadEXIF(image.Data)
+		if err != nil {
+			logging.Warnf("capture: ignoring unreadable EXIF: %v", err)
+		}
+		if exif != nil {
+			if m.Latitude == nil && exif.Latitude != nil {
+				if err := validPosition(*exif.Latitude, *exif.Longitude); err != nil {
+					logging.Warnf("capture: ignoring EXIF position: %v", err)
+				} else {
+					m.Latitude, m.Longitude, m.LocationSource = exif.Latitude, exif.Longitude, SourceEXIF
+				}
+			}
+			if m.CapturedAt == nil && exif.CapturedAt != nil {
+				m.CapturedAt, m.TimeSource = exif.CapturedAt, SourceEXIF
+			}
+		}
+	}
+
+	if m.IsZero() {
+		return nil, nil
+	}
+	return &m, nil
+}
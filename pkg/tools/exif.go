@@ -0,0 +1,238 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EXIFData is the subset of JPEG EXIF metadata used for plate reads.
+type EXIFData struct {
+	CapturedAt *time.Time
+	Latitude   *float64
+	Longitude  *float64
+}
+
+// EXIF tags read by ReadEXIF.
+const (
+	tagDateTime           = 0x0132
+	tagExifIFD            = 0x8769
+	tagGPSIFD             = 0x8825
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+	tagGPSLatitudeRef     = 0x0001
+	tagGPSLatitude        = 0x0002
+	tagGPSLongitudeRef    = 0x0003
+	tagGPSLongitude       = 0x0004
+)
+
+// exifTypeSizes maps TIFF field types to their size in bytes.
+var exifTypeSizes = map[uint16]uint32{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	7:  1, // UNDEFINED
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+// ReadEXIF extracts the capture time and GPS position from a JPEG's EXIF
+// block. It returns nil without an error when the image is not a JPEG or has
+// no EXIF data; a malformed EXIF block is an error.
+//
+// EXIF timestamps carry no zone unless the camera writes OffsetTimeOriginal,
+// so zoneless times are taken as UTC.
+func ReadEXIF(data []byte) (*EXIFData, error) {
+	tiff := findEXIFSegment(data)
+	if tiff == nil {
+		return nil, nil
+	}
+	if len(tiff) < 8 {
+		return nil, fmt.Errorf("truncated EXIF header")
+	}
+
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(tiff, []byte("II*\x00")):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(tiff, []byte("MM\x00*")):
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("invalid EXIF header")
+	}
+
+	r := &exifReader{data: tiff, order: order}
+	ifd0, err := r.readIFD(order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid EXIF IFD0: %w", err)
+	}
+
+	exif := &EXIFData{}
+	dateTime := r.ascii(ifd0[tagDateTime])
+	offset := ""
+
+	if entry, ok := ifd0[tagExifIFD]; ok {
+		sub, err := r.readIFD(r.uint32(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXIF sub-IFD: %w", err)
+		}
+		if original := r.ascii(sub[tagDateTimeOriginal]); original != "" {
+			dateTime = original
+		}
+		offset = r.ascii(sub[tagOffsetTimeOriginal])
+	}
+	if t, ok := parseEXIFTime(dateTime, offset); ok {
+		exif.CapturedAt = &t
+	}
+
+	if entry, ok := ifd0[tagGPSIFD]; ok {
+		gps, err := r.readIFD(r.uint32(entry))
+		if err != nil {
+			return nil, fmt.Errorf("invalid EXIF GPS IFD: %w", err)
+		}
+		lat, latOK := r.coordinate(gps[tagGPSLatitude], r.ascii(gps[tagGPSLatitudeRef]), "S")
+		lon, lonOK := r.coordinate(gps[tagGPSLongitude], r.ascii(gps[tagGPSLongitudeRef]), "W")
+		if latOK && lonOK {
+			exif.Latitude, exif.Longitude = &lat, &lon
+		}
+	}
+	return exif, nil
+}
+
+// findEXIFSegment walks the JPEG markers and returns the TIFF payload of the
+// APP1 Exif segment, or nil if there is none.
+func findEXIFSegment(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan or end of image
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return nil
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// exifEntry is a raw IFD entry; value holds the data itself when it fits in
+// four bytes, otherwise the offset to it.
+type exifEntry struct {
+	typ   uint16
+	count uint32
+	value []byte
+}
+
+type exifReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func (r *exifReader) readIFD(offset uint32) (map[uint16]exifEntry, error) {
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return nil, fmt.Errorf("offset %d out of range", offset)
+	}
+	n := int(r.order.Uint16(r.data[offset:]))
+	start := int(offset) + 2
+	if start+n*12 > len(r.data) {
+		return nil, fmt.Errorf("%d entries overrun the segment", n)
+	}
+
+	entries := make(map[uint16]exifEntry, n)
+	for i := 0; i < n; i++ {
+		e := r.data[start+i*12 : start+(i+1)*12]
+		entries[r.order.Uint16(e[0:2])] = exifEntry{
+			typ:   r.order.Uint16(e[2:4]),
+			count: r.order.Uint32(e[4:8]),
+			value: e[8:12],
+		}
+	}
+	return entries, nil
+}
+
+// bytes returns the data for an entry, following its offset when needed.
+func (r *exifReader) bytes(e exifEntry) []byte {
+	size := uint64(exifTypeSizes[e.typ]) * uint64(e.count)
+	if size == 0 {
+		return nil
+	}
+	if size <= 4 {
+		return e.value[:size]
+	}
+	offset := uint64(r.order.Uint32(e.value))
+	if offset+size > uint64(len(r.data)) {
+		return nil
+	}
+	return r.data[offset : offset+size]
+}
+
+func (r *exifReader) uint32(e exifEntry) uint32 {
+	if e.typ == 3 {
+		return uint32(r.order.Uint16(e.value))
+	}
+	return r.order.Uint32(e.value)
+}
+
+func (r *exifReader) ascii(e exifEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	return strings.TrimRight(string(r.bytes(e)), "\x00 ")
+}
+
+// coordinate converts a degrees/minutes/seconds GPS rational triple to
+// decimal degrees, negated when ref matches negativeRef.
+func (r *exifReader) coordinate(e exifEntry, ref, negativeRef string) (float64, bool) {
+	if e.typ != 5 || e.count != 3 {
+		return 0, false
+	}
+	b := r.bytes(e)
+	if b == nil {
+		return 0, false
+	}
+	var parts [3]float64
+	for i := range parts {
+		num := r.order.Uint32(b[i*8:])
+		den := r.order.Uint32(b[i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		parts[i] = float64(num) / float64(den)
+	}
+	deg := parts[0] + parts[1]/60 + parts[2]/3600
+	if strings.EqualFold(ref, negativeRef) {
+		deg = -deg
+	}
+	return deg, true
+}
+
+func parseEXIFTime(value, offset string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t.UTC(), true
+		}
+	}
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
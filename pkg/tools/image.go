@@ -0,0 +1,105 @@
+package tools
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"punkplod23/go-agent-ollama-slm/config"
+	"strings"
+)
+
+// supportedImageTypes are the sniffed content types accepted for ALPR.
+// GIFs are converted to PNG before being forwarded.
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// PreparedImage is an uploaded image after validation, ready for the ALPR backend.
+type PreparedImage struct {
+	Data     []byte
+	MimeType string
+	Width    int
+	Height   int
+}
+
+// DataURI renders the image in the "data:image/png;base64,..." form the ALPR backend expects.
+func (p *PreparedImage) DataURI() string {
+	return "data:" + p.MimeType + ";base64," + base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// DecodeBase64Image strips any data-URI prefix and whitespace from a base64
+// image string and returns the raw bytes.
+func DecodeBase64Image(imageBase64 string) ([]byte, error) {
+	s := strings.TrimSpace(imageBase64)
+	if strings.HasPrefix(s, "data:") {
+		comma := strings.IndexByte(s, ',')
+		if comma < 0 || !strings.Contains(s[:comma], ";base64") {
+			return nil, fmt.Errorf("malformed data URI: expected data:<type>;base64,<data>")
+		}
+		s = s[comma+1:]
+	}
+
+	// Payloads pasted from files often carry line breaks.
+	s = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\r' || r == ' ' || r == '\t' {
+			return -1
+		}
+		return r
+	}, s)
+
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("image data is not valid base64")
+}
+
+// PrepareImage validates raw image bytes against the configured size and
+// dimension limits and converts them to a format the ALPR backend accepts.
+func PrepareImage(data []byte, cfg *config.Config) (*PreparedImage, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("image is empty")
+	}
+	if cfg.MaxImageBytes > 0 && int64(len(data)) > cfg.MaxImageBytes {
+		return nil, fmt.Errorf("image is %d bytes, limit is %d", len(data), cfg.MaxImageBytes)
+	}
+
+	mimeType := http.DetectContentType(data)
+	if !supportedImageTypes[mimeType] {
+		return nil, fmt.Errorf("unsupported image type %q: expected PNG, JPEG or GIF", mimeType)
+	}
+
+	imgCfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image header: %w", err)
+	}
+	if (cfg.MaxImageWidth > 0 && imgCfg.Width > cfg.MaxImageWidth) || (cfg.MaxImageHeight > 0 && imgCfg.Height > cfg.MaxImageHeight) {
+		return nil, fmt.Errorf("image is %dx%d, limit is %dx%d", imgCfg.Width, imgCfg.Height, cfg.MaxImageWidth, cfg.MaxImageHeight)
+	}
+
+	prepared := &PreparedImage{Data: data, MimeType: mimeType, Width: imgCfg.Width, Height: imgCfg.Height}
+
+	if mimeType == "image/gif" {
+		// The ALPR backend only reads PNG and JPEG; use the first frame.
+		img, err := gif.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode GIF: %w", err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to convert GIF to PNG: %w", err)
+		}
+		prepared.Data = buf.Bytes()
+		prepared.MimeType = "image/png"
+	}
+
+	return prepared, nil
+}
@@ -0,0 +1,220 @@
+package tools
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"strings"
+)
+
+// Tool backends selectable with ALPRBACKEND and DVSABACKEND.
+const (
+	BackendRemote  = "remote"
+	BackendOffline = "offline"
+)
+
+// Fixtures answers ALPR and DVSA requests from files, for demos and tests
+// without network access. The directory is laid out as:
+//
+//	alpr/<sha256 of image>.json   ALPR response for one image
+//	alpr/<name>.jpg + <name>.json an image with its response alongside
+//	alpr/default.json             response for any other image (optional)
+//	dvsa/<REGISTRATION>.json      DVSA record, e.g. dvsa/AB12CDE.json
+//
+// ALPR files hold a process-base64-image response ({"alpr_results": [...]}).
+// Files are read on every call, so fixtures can be added while running.
+type Fixtures struct {
+	Dir string
+}
+
+// ImageHash is the fixture key for an image: the hex SHA-256 of its bytes.
+func ImageHash(image []byte) string {
+	sum := sha256.Sum256(image)
+	return hex.EncodeToString(sum[:])
+}
+
+// Recognise returns the fixture response for an image. An image with no
+// fixture and no default.json gets an empty result, as if no plate was seen.
+func (f Fixtures) Recognise(image []byte) (*ProcessImageResponse, error) {
+	hash := ImageHash(image)
+	dir := filepath.Join(f.Dir, "alpr")
+
+	path, err := f.alprFixture(dir, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ProcessImageResponse{ALPRResults: []ALPRResult{}}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ALPR fixture: %w", err)
+		}
+		if err := json.Unmarshal(data, response); err != nil {
+			return nil, fmt.Errorf("failed to parse ALPR fixture %s: %w", path, err)
+		}
+		logging.Debugf("🧪 offline ALPR: image %s answered from %s", hash[:12], filepath.Base(path))
+	} else {
+		logging.Warnf("🧪 offline ALPR: no fixture for image %s", hash)
+	}
+
+	if response.Message == "" {
+		response.Message = "processed offline"
+	}
+	response.SizeBytes = len(image)
+	response.InferredMimeType = http.DetectContentType(image)
+	return response, nil
+}
+
+// alprFixture finds the fixture file for an image hash, or "" if there is none.
+func (f Fixtures) alprFixture(dir, hash string) (string, error) {
+	if path := filepath.Join(dir, hash+".json"); fileExists(path) {
+		return path, nil
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("failed to read ALPR fixtures: %w", err)
+	}
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif") {
+			continue
+		}
+		sidecar := filepath.Join(dir, strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))+".json")
+		if !fileExists(sidecar) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err == nil && ImageHash(data) == hash {
+			return sidecar, nil
+		}
+	}
+
+	if path := filepath.Join(dir, "default.json"); fileExists(path) {
+		return path, nil
+	}
+	return "", nil
+}
+
+// Vehicle returns the DVSA fixture for a registration, or ErrVehicleNotFound.
+func (f Fixtures) Vehicle(registrationID string) (*VehicleResponse, error) {
+	reg := strings.ToUpper(strings.Join(strings.Fields(registrationID), ""))
+	if reg == "" || strings.ContainsAny(reg, `/\.`) {
+		return nil, fmt.Errorf("offline DVSA: registration %q: %w", registrationID, ErrVehicleNotFound)
+	}
+
+	path := filepath.Join(f.Dir, "dvsa", reg+".json")
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("offline DVSA: registration %s: %w", reg, ErrVehicleNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DVSA fixture: %w", err)
+	}
+
+	var vehicle VehicleResponse
+	if err := json.Unmarshal(data, &vehicle); err != nil {
+		return nil, fmt.Errorf("failed to parse DVSA fixture %s: %w", path, err)
+	}
+	if vehicle.RegistrationNumber == "" {
+		vehicle.RegistrationNumber = reg
+	}
+	return &vehicle, nil
+}
+
+// Handler serves the fixtures over the same HTTP API as the real ALPR
+// backend (POST /process-base64-image/) and DVSA
+// (GET /vehicle-enquiry/v1/vehicles/{registration}), so the remote clients
+// can be exercised against them.
+func (f Fixtures) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/process-base64-image/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var req ProcessImageRequest
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<20)).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		image, err := DecodeBase64Image(req.ImageBase64)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		response, err := f.Recognise(image)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	})
+	mux.HandleFunc("/vehicle-enquiry/v1/vehicles/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		vehicle, err := f.Vehicle(strings.TrimPrefix(r.URL.Path, "/vehicle-enquiry/v1/vehicles/"))
+		if errors.Is(err, ErrVehicleNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(vehicle)
+	})
+	return mux
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
+
+// ConfigureBackends checks ALPRBACKEND and DVSABACKEND and, when either is
+// offline, that FIXTURESDIR exists.
+func ConfigureBackends(cfg *config.Config) error {
+	offline := false
+	for name, backend := range map[string]string{"ALPRBACKEND": cfg.ALPRBackend, "DVSABACKEND": cfg.DVSABackend} {
+		switch strings.ToLower(backend) {
+		case "", BackendRemote:
+		case BackendOffline:
+			offline = true
+		default:
+			return fmt.Errorf("unknown %s %q: use remote or offline", name, backend)
+		}
+	}
+	if !offline {
+		return nil
+	}
+	if info, err := os.Stat(cfg.FixturesDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("fixtures directory %q not found: set FIXTURESDIR", cfg.FixturesDir)
+	}
+	logging.Infof("🧪 Offline tools: ALPR=%s DVSA=%s fixtures=%s", backendName(cfg.ALPRBackend), backendName(cfg.DVSABackend), cfg.FixturesDir)
+	return nil
+}
+
+func backendName(backend string) string {
+	if backend == "" {
+		return BackendRemote
+	}
+	return strings.ToLower(backend)
+}
+
+func isOffline(backend string) bool {
+	return strings.EqualFold(backend, BackendOffline)
+}
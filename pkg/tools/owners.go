@@ -0,0 +1,420 @@
+package tools
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/kvstore"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrOwnerNotFound is returned when no owner is registered for a plate.
+var ErrOwnerNotFound = errors.New("owner not found")
+
+// Owner store backends selectable through OWNERSTORETYPE.
+const (
+	OwnerStoreMemory = "memory"
+	OwnerStoreCSV    = "csv"
+	OwnerStoreJSON   = "json"
+	OwnerStoreKV     = "kv"
+)
+
+// OwnerRecord maps a registration to the owner that holds it in our fleet data.
+type OwnerRecord struct {
+	Registration string    `json:"registration"`
+	OwnerID      string    `json:"owner_id"`
+	Notes        string    `json:"notes,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// OwnerStore is the registration-to-owner registry behind GetOwnerID.
+type OwnerStore interface {
+	// Lookup returns ErrOwnerNotFound when the registration is unknown.
+	Lookup(registration string) (*OwnerRecord, error)
+	Put(record OwnerRecord) error
+	Delete(registration string) error
+	List() ([]OwnerRecord, error)
+}
+
+var (
+	ownerStoreMu sync.RWMutex
+	ownerStore   OwnerStore = NewMemoryOwnerStore()
+)
+
+// SetOwnerStore replaces the registry used by GetOwnerID.
+func SetOwnerStore(store OwnerStore) {
+	ownerStoreMu.Lock()
+	defer ownerStoreMu.Unlock()
+	ownerStore = store
+}
+
+// Owners returns the registry used by GetOwnerID.
+func Owners() OwnerStore {
+	ownerStoreMu.RLock()
+	defer ownerStoreMu.RUnlock()
+	return ownerStore
+}
+
+// OpenOwnerStore builds the owner registry selected by the configuration.
+func OpenOwnerStore(cfg *config.Config) (OwnerStore, error) {
+	switch strings.ToLower(cfg.OwnerStoreType) {
+	case "", OwnerStoreMemory:
+		return NewMemoryOwnerStore(), nil
+	case OwnerStoreCSV, OwnerStoreJSON:
+		return NewFileOwnerStore(cfg.OwnerStorePath, strings.ToLower(cfg.OwnerStoreType))
+	case OwnerStoreKV:
+		return NewKVOwnerStore(cfg.OwnerStorePath)
+	default:
+		return nil, fmt.Errorf("unknown owner store type %q", cfg.OwnerStoreType)
+	}
+}
+
+// --- File-backed store (CSV or JSON) ---
+
+// FileOwnerStore keeps the registry in memory and rewrites the whole CSV or
+// JSON file on every change. It is meant for hand-maintained fleet lists.
+type FileOwnerStore struct {
+	mu      sync.RWMutex
+	path    string
+	format  string
+	records map[string]OwnerRecord
+}
+
+// NewMemoryOwnerStore returns an empty registry that is not persisted.
+func NewMemoryOwnerStore() *FileOwnerStore {
+	return &FileOwnerStore{records: make(map[string]OwnerRecord)}
+}
+
+// NewFileOwnerStore loads the registry from a CSV or JSON file. A missing file
+// starts an empty registry that is created on the first write.
+func NewFileOwnerStore(path, format string) (*FileOwnerStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("OWNERSTOREPATH is required for a %s owner store", format)
+	}
+	s := &FileOwnerStore{path: path, format: format, records: make(map[string]OwnerRecord)}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open owner file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	records, err := ParseOwnerRecords(file, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load owner file %s: %w", path, err)
+	}
+	for _, r := range records {
+		s.records[r.Registration] = r
+	}
+	return s, nil
+}
+
+func (s *FileOwnerStore) Lookup(registration string) (*OwnerRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.records[NormalisePlate(registration)]
+	if !ok {
+		return nil, fmt.Errorf("registration %s: %w", registration, ErrOwnerNotFound)
+	}
+	return &r, nil
+}
+
+func (s *FileOwnerStore) Put(record OwnerRecord) error {
+	record, err := cleanOwnerRecord(record)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := maps.Clone(s.records)
+	next[record.Registration] = record
+	return s.replaceLocked(next)
+}
+
+// PutAll upserts many records with a single rewrite of the file. Every record
+// is validated first, so a bad record leaves the registry unchanged.
+func (s *FileOwnerStore) PutAll(records []OwnerRecord) error {
+	cleaned := make([]OwnerRecord, len(records))
+	for i, record := range records {
+		var err error
+		if cleaned[i], err = cleanOwnerRecord(record); err != nil {
+			return fmt.Errorf("record %d: %w", i+1, err)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := maps.Clone(s.records)
+	for _, record := range cleaned {
+		next[record.Registration] = record
+	}
+	return s.replaceLocked(next)
+}
+
+func (s *FileOwnerStore) Delete(registration string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	registration = NormalisePlate(registration)
+	if _, ok := s.records[registration]; !ok {
+		return fmt.Errorf("registration %s: %w", registration, ErrOwnerNotFound)
+	}
+	next := maps.Clone(s.records)
+	delete(next, registration)
+	return s.replaceLocked(next)
+}
+
+func (s *FileOwnerStore) List() ([]OwnerRecord, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	records := make([]OwnerRecord, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sortOwnerRecords(records)
+	return records, nil
+}
+
+// replaceLocked makes next the registry once it has been saved, so memory
+// never holds changes the file does not.
+func (s *FileOwnerStore) replaceLocked(next map[string]OwnerRecord) error {
+	if err := s.save(next); err != nil {
+		return err
+	}
+	s.records = next
+	return nil
+}
+
+// save writes the registry to a temporary file and renames it over the
+// original so readers never see a half-written file.
+func (s *FileOwnerStore) save(registry map[string]OwnerRecord) error {
+	if s.path == "" {
+		return nil
+	}
+
+	records := make([]OwnerRecord, 0, len(registry))
+	for _, r := range registry {
+		records = append(records, r)
+	}
+	sortOwnerRecords(records)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create owner file directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".owners-*")
+	if err != nil {
+		return fmt.Errorf("failed to write owner file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if s.format == OwnerStoreCSV {
+		w := csv.NewWriter(tmp)
+		w.Write([]string{"registration", "owner_id", "notes", "updated_at"})
+		for _, r := range records {
+			w.Write([]string{r.Registration, r.OwnerID, r.Notes, r.UpdatedAt.Format(time.RFC3339)})
+		}
+		w.Flush()
+		err = w.Error()
+	} else {
+		enc := json.NewEncoder(tmp)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(records)
+	}
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write owner file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write owner file: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// --- Embedded key-value store ---
+
+const ownerKeyPrefix = "owner/"
+
+// KVOwnerStore keeps the registry in an embedded key-value store, which suits
+// larger fleets that are maintained through the admin API.
+type KVOwnerStore struct {
+	db *kvstore.Store
+}
+
+// NewKVOwnerStore opens (or creates) the key-value registry at path.
+func NewKVOwnerStore(path string) (*KVOwnerStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("OWNERSTOREPATH is required for a kv owner store")
+	}
+	db, err := kvstore.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &KVOwnerStore{db: db}, nil
+}
+
+func (s *KVOwnerStore) Lookup(registration string) (*OwnerRecord, error) {
+	var r OwnerRecord
+	found, err := s.db.Get(ownerKeyPrefix+NormalisePlate(registration), &r)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("registration %s: %w", registration, ErrOwnerNotFound)
+	}
+	return &r, nil
+}
+
+func (s *KVOwnerStore) Put(record OwnerRecord) error {
+	record, err := cleanOwnerRecord(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(ownerKeyPrefix+record.Registration, record)
+}
+
+func (s *KVOwnerStore) Delete(registration string) error {
+	if _, err := s.Lookup(registration); err != nil {
+		return err
+	}
+	return s.db.Delete(ownerKeyPrefix + NormalisePlate(registration))
+}
+
+func (s *KVOwnerStore) List() ([]OwnerRecord, error) {
+	var records []OwnerRecord
+	var decodeErr error
+	s.db.Scan(ownerKeyPrefix, func(_ string, value json.RawMessage) bool {
+		var r OwnerRecord
+		if decodeErr = json.Unmarshal(value, &r); decodeErr != nil {
+			return false
+		}
+		records = append(records, r)
+		return true
+	})
+	return records, decodeErr
+}
+
+// Close releases the underlying store.
+func (s *KVOwnerStore) Close() error {
+	return s.db.Close()
+}
+
+// --- Import helpers ---
+
+// ParseOwnerRecords reads owner mappings from CSV or JSON. CSV input needs
+// registration and owner_id columns (a header row is optional); JSON input is
+// an array of OwnerRecord objects.
+func ParseOwnerRecords(r io.Reader, format string) ([]OwnerRecord, error) {
+	switch format {
+	case OwnerStoreJSON:
+		var records []OwnerRecord
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			if err == io.EOF {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("invalid owner JSON: %w", err)
+		}
+		for i := range records {
+			cleaned, err := cleanOwnerRecord(records[i])
+			if err != nil {
+				return nil, fmt.Errorf("record %d: %w", i+1, err)
+			}
+			records[i] = cleaned
+		}
+		return records, nil
+	case OwnerStoreCSV:
+		return parseOwnerCSV(r)
+	default:
+		return nil, fmt.Errorf("unsupported owner import format %q", format)
+	}
+}
+
+func parseOwnerCSV(r io.Reader) ([]OwnerRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid owner CSV: %w", err)
+	}
+
+	cols := map[string]int{"registration": 0, "owner_id": 1, "notes": 2, "updated_at": 3}
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), "registration") {
+		cols = map[string]int{}
+		for i, name := range rows[0] {
+			cols[strings.ToLower(strings.TrimSpace(name))] = i
+		}
+		rows = rows[1:]
+		if _, ok := cols["owner_id"]; !ok {
+			return nil, fmt.Errorf("owner CSV header must include registration and owner_id")
+		}
+	}
+
+	field := func(row []string, name string) string {
+		i, ok := cols[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	var records []OwnerRecord
+	for n, row := range rows {
+		record := OwnerRecord{
+			Registration: field(row, "registration"),
+			OwnerID:      field(row, "owner_id"),
+			Notes:        field(row, "notes"),
+		}
+		if ts := field(row, "updated_at"); ts != "" {
+			record.UpdatedAt, _ = time.Parse(time.RFC3339, ts)
+		}
+		cleaned, err := cleanOwnerRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", n+1, err)
+		}
+		records = append(records, cleaned)
+	}
+	return records, nil
+}
+
+// ImportOwners upserts every record into the store and returns how many were written.
+func ImportOwners(store OwnerStore, records []OwnerRecord) (int, error) {
+	if bulk, ok := store.(interface{ PutAll([]OwnerRecord) error }); ok {
+		if err := bulk.PutAll(records); err != nil {
+			return 0, fmt.Errorf("failed to import owners: %w", err)
+		}
+		return len(records), nil
+	}
+	for i, r := range records {
+		if err := store.Put(r); err != nil {
+			return i, fmt.Errorf("failed to import %s: %w", r.Registration, err)
+		}
+	}
+	return len(records), nil
+}
+
+func cleanOwnerRecord(r OwnerRecord) (OwnerRecord, error) {
+	r.Registration = NormalisePlate(r.Registration)
+	r.OwnerID = strings.TrimSpace(r.OwnerID)
+	if r.Registration == "" || r.OwnerID == "" {
+		return r, fmt.Errorf("registration and owner_id are required")
+	}
+	if r.UpdatedAt.IsZero() {
+		r.UpdatedAt = time.Now().UTC()
+	}
+	return r, nil
+}
+
+func sortOwnerRecords(records []OwnerRecord) {
+	sort.Slice(records, func(i, j int) bool { return records[i].Registration < records[j].Registration })
+}
@@ -2,13 +2,17 @@ package tools
 
 import (
 	"bytes"
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"net/url"
 	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/resilience"
 	"strings"
 	"time"
 )
@@ -66,6 +70,8 @@ type ProcessImageResponse struct {
 	SizeBytes        int          `json:"size_bytes"`
 	InferredMimeType string       `json:"inferred_mime_type"`
 	ALPRResults      []ALPRResult `json:"alpr_results"` // The array we need to process
+	// Capture is filled in by this service, not the ALPR backend.
+	Capture *CaptureMetadata `json:"capture,omitempty"`
 }
 
 // ProcessImageRequest maps directly to the expected JSON body for your API
@@ -75,122 +81,158 @@ type ProcessImageRequest struct {
 	ImageBase64 string `json:"image_base64"`
 }
 
-// In a real system, this would be a database lookup or another API call.
-func mapRegistrationToOwnerID(regID string) string {
-	// Dummy mapping: For any valid plate, return a dummy owner ID
-	if len(regID) > 3 {
-		return "OWNER-" + strings.ToUpper(regID)
-	}
-	return ""
+// DVSAPolicy retries DVSA lookups, which are plain GETs.
+var DVSAPolicy = resilience.DefaultPolicy
+
+// ALPRPolicy retries ALPR calls even though they are POSTs: recognising the
+// same image twice has no side effects, and the ALPR box restarts regularly.
+var ALPRPolicy = resilience.Policy{
+	MaxAttempts:        4,
+	BaseDelay:          500 * time.Millisecond,
+	MaxDelay:           4 * time.Second,
+	RetryNonIdempotent: true,
+	FailureThreshold:   5,
+	OpenDuration:       20 * time.Second,
 }
 
-// and immediately fails the connection attempt.
-func noHostLookupDialer(ctx context.Context, network, addr string) (net.Conn, error) {
-	// The 'addr' typically contains "hostname:port".
-	// By returning an error here, we stop the network stack before
-	// it can initiate a DNS lookup for the 'hostname' part of 'addr'.
+// Both upstreams dial through the shared egress policy, and can be recorded
+// to or replayed from cassettes.
+var (
+	dvsaTransport = cassette.Wrap("dvsa", egress.Transport())
+	alprTransport = cassette.Wrap("alpr", egress.Transport())
+)
 
-	return nil, fmt.Errorf("host lookup disabled: network connection to %s is prohibited", addr)
+// Register the breakers up front so health reports them before the first call.
+func init() {
+	resilience.BreakerFor("dvsa", DVSAPolicy)
+	resilience.BreakerFor("alpr", ALPRPolicy)
 }
 
-func safeDialer(ctx context.Context, network, addr string) (net.Conn, error) {
-	host, _, err := net.SplitHostPort(addr)
-	if err != nil {
-		// If SplitHostPort fails, treat the whole address as the host
-		host = addr
-	}
-
-	// 1. Check if the host is a valid IP address.
-	// If net.ParseIP returns a non-nil IP, it's an IP address.
-	if net.ParseIP(host) != nil {
-		// It's a direct IP address (e.g., "127.0.0.1" or "192.168.1.1").
-		// Use the default Go dialer to attempt the connection directly.
-		return (&net.Dialer{
-			Timeout:   5 * time.Second,
-			KeepAlive: 5 * time.Second,
-		}).DialContext(ctx, network, addr)
-	}
-
-	// 2. If it's not a direct IP, it must be a hostname (like "google.com" or "alpnr.localhost").
-	// We block all hostnames to prevent external DNS lookups.
-	return nil, fmt.Errorf("hostname lookup blocked: connection attempt to '%s' is prohibited", host)
+// getDVSAClient returns the client used for Tool B calls.
+func getDVSAClient() *http.Client {
+	return resilience.NewClient("dvsa", dvsaTransport, DVSAPolicy, 30*time.Second)
 }
 
-func GetClientWithHostnamesBlocked() *http.Client {
+// getALPRClient returns the client used for Tool A calls.
+func getALPRClient() *http.Client {
+	return resilience.NewClient("alpr", alprTransport, ALPRPolicy, 30*time.Second)
+}
 
-	transport := &http.Transport{
-		DialContext: safeDialer,
-		// ... standard settings
-	}
+// ErrVehicleNotFound is returned when DVSA has no record of a registration.
+var ErrVehicleNotFound = errors.New("vehicle not found")
 
-	return &http.Client{
-		Transport: transport,
-		Timeout:   30 * time.Second,
-	}
-}
+// ErrInvalidRegistration is returned for a registration with no letters or digits.
+var ErrInvalidRegistration = errors.New("invalid registration")
 
-// Tool B: DVSA Vehicle Enquiry API
-func GetOwnerID(registrationID string, cfg *config.Config) (string, error) {
+// fetchVehicle fetches the full DVSA record for a registration, bypassing the cache.
+// A 404 from DVSA is reported as ErrVehicleNotFound so callers can try alternatives.
+func fetchVehicle(registrationID string, cfg *config.Config) (*VehicleResponse, error) {
 	if registrationID == "" {
-		return "", fmt.Errorf("Tool B received empty registration ID")
+		return nil, fmt.Errorf("Tool B received empty registration ID")
+	}
+	if isOffline(cfg.DVSABackend) {
+		return Fixtures{Dir: cfg.FixturesDir}.Vehicle(registrationID)
 	}
 
 	// 1. Construct the URL using direct IP address to avoid lookup issues
 	// We assume DVSA service is listening at the root of 127.0.0.1 (via Traefik/Ingress)
 	// NOTE: Replace 127.0.0.1 with the correct K8s-exposed IP/Port if needed.
-	toolBURL := fmt.Sprintf(cfg.DVSAAPIURL+"vehicle-enquiry/v1/vehicles/%s", registrationID)
+	toolBURL := cfg.DVSAAPIURL + "vehicle-enquiry/v1/vehicles/" + url.PathEscape(registrationID)
 
-	// Use the client that allows IP connections but blocks hostnames
-	client := GetClientWithHostnamesBlocked()
+	// The client dials through the egress policy and DVSA breaker
+	client := getDVSAClient()
 
 	// 2. Create the GET request
 	req, err := http.NewRequest("GET", toolBURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create Tool B request: %w", err)
+		return nil, fmt.Errorf("failed to create Tool B request: %w", err)
 	}
 
 	// 3. Execute Request
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute Tool B request: %w", err)
+		return nil, fmt.Errorf("failed to execute Tool B request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	// 4. Check Status Code
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("Tool B: registration %s: %w", registrationID, ErrVehicleNotFound)
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		responseBody, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("Tool B call failed with status %d: %s", resp.StatusCode, string(responseBody))
+		return nil, fmt.Errorf("Tool B call failed with status %d: %s", resp.StatusCode, string(responseBody))
 	}
 
 	// 5. Decode Response
 	var apiResponse VehicleResponse
 	if err := json.NewDecoder(resp.Body).Decode(&apiResponse); err != nil {
-		return "", fmt.Errorf("failed to decode Tool B response: %w", err)
+		return nil, fmt.Errorf("failed to decode Tool B response: %w", err)
 	}
 
-	// 6. Map to Owner ID
-	// Since the actual API doesn't return owner_id, we map the registration ID to a dummy owner ID.
-	ownerID := mapRegistrationToOwnerID(apiResponse.RegistrationNumber)
+	return &apiResponse, nil
+}
 
-	if ownerID == "" {
-		return "", fmt.Errorf("Tool B: Failed to map registration %s to an owner ID", apiResponse.RegistrationNumber)
+// OwnerIDForVehicle maps a DVSA record to an owner ID using the owner registry.
+// It returns ErrOwnerNotFound when the registration has no registered owner.
+func OwnerIDForVehicle(vehicle *VehicleResponse) (string, error) {
+	// The DVSA API doesn't return owner details, so ownership comes from our own fleet data.
+	record, err := Owners().Lookup(vehicle.RegistrationNumber)
+	if err != nil {
+		return "", fmt.Errorf("Tool B: Failed to map registration %s to an owner ID: %w", vehicle.RegistrationNumber, err)
 	}
 
-	fmt.Printf("✅ Tool B: Vehicle details retrieved. Mapped to Owner ID: %s\n", ownerID)
-	return ownerID, nil
+	return record.OwnerID, nil
 }
 
-// Tool A: External ALPR API
-func ProcessBase64Image(imageBase64 string, cfg *config.Config) (string, error) {
-	// ... [Input validation and Request Payload building remain the same] ...
+// Tool B: DVSA Vehicle Enquiry API
+func GetOwnerID(registrationID string, cfg *config.Config) (string, error) {
+	vehicle, err := LookupVehicle(registrationID, cfg)
+	if err != nil {
+		return "", err
+	}
 
-	fmt.Println(imageBase64)
+	ownerID, err := OwnerIDForVehicle(vehicle)
+	if err != nil {
+		return "", err
+	}
+
+	logging.Debugf("✅ Tool B: Vehicle details retrieved. Mapped to Owner ID: %s", ownerID)
+	return ownerID, nil
+}
+
+// RecognisePlates decodes a base64 image (bare or a data URI) and sends it to
+// the ALPR backend, returning every detection it made.
+func RecognisePlates(imageBase64 string, cfg *config.Config) (*ProcessImageResponse, error) {
 	if imageBase64 == "" {
-		return "", fmt.Errorf("invalid image data provided to Tool A")
+		return nil, fmt.Errorf("invalid image data provided to Tool A")
+	}
+	data, err := DecodeBase64Image(imageBase64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image data provided to Tool A: %w", err)
+	}
+	return RecognisePlateBytes(data, cfg)
+}
+
+// RecognisePlateBytes validates raw image bytes against the upload limits
+// and sends them to the ALPR backend.
+func RecognisePlateBytes(data []byte, cfg *config.Config) (*ProcessImageResponse, error) {
+	prepared, err := PrepareImage(data, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image data provided to Tool A: %w", err)
+	}
+	return RecognisePreparedImage(prepared, cfg)
+}
+
+// RecognisePreparedImage sends an image that PrepareImage has already
+// validated to the ALPR backend.
+func RecognisePreparedImage(prepared *PreparedImage, cfg *config.Config) (*ProcessImageResponse, error) {
+	if isOffline(cfg.ALPRBackend) {
+		return Fixtures{Dir: cfg.FixturesDir}.Recognise(prepared.Data)
 	}
 
 	requestPayload := ProcessImageRequest{
-		ImageBase64: imageBase64,
+		ImageBase64: prepared.DataURI(),
 	}
 
 	var apiResponse ProcessImageResponse
@@ -201,49 +243,68 @@ func ProcessBase64Image(imageBase64 string, cfg *config.Config) (string, error)
 	reqData, err := json.Marshal(requestPayload)
 
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal Tool A request: %w", err)
+		return nil, fmt.Errorf("failed to marshal Tool A request: %w", err)
 	}
 
 	req, err := http.NewRequest("POST", toolAURL, bytes.NewBuffer(reqData))
 
 	if err != nil {
-		return "", fmt.Errorf("failed to create Tool A request: %w", err)
+		return nil, fmt.Errorf("failed to create Tool A request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
+	client := getALPRClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to execute Tool A request: %w", err)
+		return nil, fmt.Errorf("failed to execute Tool A request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	responseBody, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("Tool A call failed with status %d: %s", resp.StatusCode, string(responseBody))
+		return nil, fmt.Errorf("Tool A call failed with status %d: %s", resp.StatusCode, string(responseBody))
 	}
 	// --- End Network Request Logic ---
 
 	// 1. Decode Response
 	if err := json.Unmarshal(responseBody, &apiResponse); err != nil {
-		return "", fmt.Errorf("failed to decode Tool A response: %w", err)
+		return nil, fmt.Errorf("failed to decode Tool A response: %w", err)
 	}
 
-	// 2. Extract the Registration ID
+	return &apiResponse, nil
+}
+
+// PrimaryRegistration extracts the registration ID from the best ALPR result.
+func PrimaryRegistration(apiResponse *ProcessImageResponse) (string, error) {
 	if len(apiResponse.ALPRResults) == 0 {
 		// No license plates were detected
 		return "", fmt.Errorf("Tool A failed to find any license plate results")
 	}
 
 	// Assume the first result is the best/only one
-	registrationID := apiResponse.ALPRResults[0].OCR.Text
+	registrationID := strings.TrimSpace(apiResponse.ALPRResults[0].OCR.Text)
 
 	if registrationID == "" {
 		return "", fmt.Errorf("Tool A result was empty: ALPR found no readable text")
 	}
 
-	fmt.Printf("✅ Tool A: Image processed successfully. Registration ID: %s\n", registrationID)
-	return strings.TrimSpace(registrationID), nil
+	return registrationID, nil
+}
+
+// Tool A: External ALPR API
+func ProcessBase64Image(imageBase64 string, cfg *config.Config) (string, error) {
+	apiResponse, err := RecognisePlates(imageBase64, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	registrationID, err := PrimaryRegistration(apiResponse)
+	if err != nil {
+		return "", err
+	}
+
+	logging.Debugf("✅ Tool A: Image processed successfully. Registration ID: %s", registrationID)
+	return registrationID, nil
 }
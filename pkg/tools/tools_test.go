@@ -0,0 +1,151 @@
+package tools_test
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"testing"
+)
+
+var record = flag.Bool("record", false, "record cassettes against the fixture server instead of replaying them")
+
+// useCassettes replays the cassettes in dir and returns a base URL for the
+// upstreams, which is never dialled. With -record it serves handler on a
+// local server and records the exchanges to dir instead.
+func useCassettes(t *testing.T, dir string, handler http.Handler) string {
+	t.Helper()
+	url := "http://upstream.test"
+	mode := cassette.ModeReplay
+	if *record {
+		srv := httptest.NewServer(handler)
+		t.Cleanup(srv.Close)
+		url, mode = srv.URL, cassette.ModeRecord
+		if err := egress.Configure(&config.Config{OpenALPRAPIURL: url}); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { egress.Configure(&config.Config{}) })
+	}
+	if err := cassette.Use(mode, dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cassette.Use(cassette.ModeOff, "") })
+	return url
+}
+
+// testImage is a small PNG; its encoding is stable, so the recorded ALPR
+// request matches on replay.
+func testImage(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+	for x := 0; x < 8; x++ {
+		img.Set(x, 1, color.RGBA{R: 255, G: 210, A: 255})
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRecognisePlateBytesReplay(t *testing.T) {
+	url := useCassettes(t, "testdata/cassettes/alpr", tools.Fixtures{Dir: "../../fixtures"}.Handler())
+	cfg := &config.Config{OpenALPRAPIURL: url}
+
+	response, err := tools.RecognisePlateBytes(testImage(t), cfg)
+	if err != nil {
+		t.Fatalf("RecognisePlateBytes: %v", err)
+	}
+	if len(response.ALPRResults) != 1 {
+		t.Fatalf("got %d ALPR results, want 1", len(response.ALPRResults))
+	}
+	result := response.ALPRResults[0]
+	if result.OCR.Text != "AB12CDE" || result.OCR.Confidence != 0.91 {
+		t.Errorf("OCR = %+v, want AB12CDE at 0.91", result.OCR)
+	}
+	want := tools.Detection{Label: "license_plate", Confidence: 0.94, BoundingBox: tools.BoundingBox{X1: 120, Y1: 210, X2: 330, Y2: 262}}
+	if result.Detection != want {
+		t.Errorf("Detection = %+v, want %+v", result.Detection, want)
+	}
+}
+
+func TestLookupVehicleReplay(t *testing.T) {
+	url := useCassettes(t, "testdata/cassettes/dvsa", tools.Fixtures{Dir: "../../fixtures"}.Handler())
+	cfg := &config.Config{DVSAAPIURL: url + "/"}
+
+	vehicle, err := tools.LookupVehicle("AB12CDE", cfg)
+	if err != nil {
+		t.Fatalf("LookupVehicle: %v", err)
+	}
+	if vehicle.RegistrationNumber != "AB12CDE" || vehicle.Make != "FORD" || vehicle.FuelType != "PETROL" ||
+		vehicle.YearOfManufacture != 2018 || vehicle.EuroStatus != 6 || vehicle.MotExpiryDate != "2026-09-30" {
+		t.Errorf("vehicle = %+v", vehicle)
+	}
+
+	if _, err := tools.LookupVehicle("ZZ99ZZZ", cfg); !errors.Is(err, tools.ErrVehicleNotFound) {
+		t.Errorf("unknown registration: got %v, want ErrVehicleNotFound", err)
+	}
+
+	// Only the normalised registration reaches DVSA.
+	if vehicle, err := tools.LookupVehicle("ab12 cde/", cfg); err != nil || vehicle.RegistrationNumber != "AB12CDE" {
+		t.Errorf("unnormalised registration: got %+v, %v", vehicle, err)
+	}
+	if _, err := tools.LookupVehicle("../", cfg); !errors.Is(err, tools.ErrInvalidRegistration) {
+		t.Errorf("registration without letters or digits: got %v, want ErrInvalidRegistration", err)
+	}
+}
+
+func TestPutAllRejectsWholeBatch(t *testing.T) {
+	path := t.TempDir() + "/owners.json"
+	store, err := tools.NewFileOwnerStore(path, tools.OwnerStoreJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(tools.OwnerRecord{Registration: "AB12CDE", OwnerID: "FLEET-001"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = store.PutAll([]tools.OwnerRecord{
+		{Registration: "AB12CDE", OwnerID: "FLEET-002"},
+		{Registration: "XY34ZZZ"},
+	})
+	if err == nil {
+		t.Fatal("batch with a record missing owner_id was accepted")
+	}
+	if r, err := store.Lookup("AB12CDE"); err != nil || r.OwnerID != "FLEET-001" {
+		t.Errorf("after rejected batch: got %+v, %v, want FLEET-001", r, err)
+	}
+	reopened, err := tools.NewFileOwnerStore(path, tools.OwnerStoreJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := reopened.List(); len(records) != 1 || records[0].OwnerID != "FLEET-001" {
+		t.Errorf("file after rejected batch = %+v", records)
+	}
+}
+
+func TestResolveCaptureRejectsOutOfRangePosition(t *testing.T) {
+	prepared, err := tools.PrepareImage(testImage(t), &config.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pos := range [][2]float64{{91, 0}, {-90.5, 0}, {0, 180.1}, {0, -181}, {math.NaN(), 0}} {
+		lat, lon := pos[0], pos[1]
+		if _, err := tools.ResolveCapture(tools.CaptureMetadata{Latitude: &lat, Longitude: &lon}, prepared); err == nil {
+			t.Errorf("position %v,%v was accepted", lat, lon)
+		}
+	}
+	lat, lon := -90.0, 180.0
+	if _, err := tools.ResolveCapture(tools.CaptureMetadata{Latitude: &lat, Longitude: &lon}, prepared); err != nil {
+		t.Errorf("position on the limits: %v", err)
+	}
+}
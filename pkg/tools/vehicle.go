@@ -0,0 +1,76 @@
+package tools
+
+import (
+	"punkplod23/go-agent-ollama-slm/config"
+	"time"
+)
+
+// DVSA date layouts.
+const (
+	dvsaDateLayout  = "2006-01-02"
+	dvsaMonthLayout = "2006-01"
+)
+
+// VehicleDetails is the full DVSA record plus fields derived from it.
+type VehicleDetails struct {
+	VehicleResponse
+
+	VehicleAgeYears         *int `json:"vehicleAgeYears,omitempty"`
+	DaysUntilMotExpiry      *int `json:"daysUntilMotExpiry,omitempty"` // negative once expired
+	MotExpired              bool `json:"motExpired"`
+	DaysSinceLastV5CIssued  *int `json:"daysSinceLastV5CIssued,omitempty"`
+	MonthsSinceRegistration *int `json:"monthsSinceFirstRegistration,omitempty"`
+	Taxed                   bool `json:"taxed"`
+}
+
+// NewVehicleDetails derives age and expiry fields from a DVSA record as of now.
+// Fields DVSA did not supply are left nil.
+func NewVehicleDetails(vehicle *VehicleResponse, now time.Time) *VehicleDetails {
+	details := &VehicleDetails{
+		VehicleResponse: *vehicle,
+		Taxed:           vehicle.TaxStatus == "Taxed",
+	}
+	today := truncateToDay(now)
+
+	if vehicle.YearOfManufacture > 0 {
+		age := today.Year() - vehicle.YearOfManufacture
+		details.VehicleAgeYears = &age
+	}
+
+	if expiry, err := time.Parse(dvsaDateLayout, vehicle.MotExpiryDate); err == nil {
+		days := daysBetween(today, expiry)
+		details.DaysUntilMotExpiry = &days
+		details.MotExpired = days < 0
+	}
+
+	if issued, err := time.Parse(dvsaDateLayout, vehicle.DateOfLastV5CIssued); err == nil {
+		days := daysBetween(issued, today)
+		details.DaysSinceLastV5CIssued = &days
+	}
+
+	if registered, err := time.Parse(dvsaMonthLayout, vehicle.MonthOfFirstRegistration); err == nil {
+		months := (today.Year()-registered.Year())*12 + int(today.Month()-registered.Month())
+		details.MonthsSinceRegistration = &months
+	}
+
+	return details
+}
+
+// GetVehicleDetails looks up a registration in DVSA and returns the full record
+// with derived fields.
+func GetVehicleDetails(registrationID string, cfg *config.Config) (*VehicleDetails, error) {
+	vehicle, err := LookupVehicle(registrationID, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewVehicleDetails(vehicle, time.Now()), nil
+}
+
+func truncateToDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// daysBetween counts whole calendar days from a to b.
+func daysBetween(a, b time.Time) int {
+	return int(truncateToDay(b).Sub(truncateToDay(a)).Hours() / 24)
+}
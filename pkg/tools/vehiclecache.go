@@ -0,0 +1,102 @@
+package tools
+
+import (
+	"errors"
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/cache"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"time"
+)
+
+// vehicleCachePersistInterval is how often the DVSA cache is snapshotted to disk.
+const vehicleCachePersistInterval = time.Minute
+
+// vehicleCache sits in front of the DVSA client. It is nil until
+// ConfigureVehicleCache runs, in which case every lookup goes upstream.
+var vehicleCache *cache.Cache[VehicleResponse]
+
+// persistStop and persistDone stop the snapshot loop and report it has
+// written its final snapshot; nil when no snapshot path is configured.
+var persistStop, persistDone chan struct{}
+
+// ConfigureVehicleCache creates the DVSA lookup cache from the configuration,
+// restoring any snapshot and starting periodic persistence when a path is set.
+func ConfigureVehicleCache(cfg *config.Config) error {
+	if cfg.DVSACacheSize <= 0 {
+		vehicleCache = nil
+		return nil
+	}
+
+	c := cache.New[VehicleResponse](cfg.DVSACacheSize, cfg.DVSACacheTTL, cfg.DVSACacheNegativeTTL)
+	if err := c.Load(cfg.DVSACachePath); err != nil {
+		// A bad snapshot only costs us warm entries; start cold rather than fail.
+		logging.Warnf("DVSA cache: ignoring snapshot: %v", err)
+	}
+	vehicleCache = c
+
+	if cfg.DVSACachePath != "" {
+		stop, done := make(chan struct{}), make(chan struct{})
+		persistStop, persistDone = stop, done
+		go func() {
+			defer close(done)
+			c.PersistEvery(cfg.DVSACachePath, vehicleCachePersistInterval, stop, func(err error) {
+				logging.Errorf("DVSA cache: %v", err)
+			})
+		}()
+	}
+	return nil
+}
+
+// FlushVehicleCache stops periodic snapshots and writes a final one, for use
+// at shutdown. The snapshot loop writes it as it stops, logging any error.
+func FlushVehicleCache(cfg *config.Config) error {
+	if persistStop == nil {
+		return vehicleCache.Save(cfg.DVSACachePath)
+	}
+	close(persistStop)
+	<-persistDone
+	persistStop, persistDone = nil, nil
+	return nil
+}
+
+// VehicleCache exposes the DVSA cache for stats and purging. It may be nil.
+func VehicleCache() *cache.Cache[VehicleResponse] {
+	return vehicleCache
+}
+
+// LookupVehicle returns the DVSA record for a registration, serving repeat
+// lookups from the cache. Unknown registrations return ErrVehicleNotFound.
+func LookupVehicle(registrationID string, cfg *config.Config) (*VehicleResponse, error) {
+	vehicle, _, err := LookupVehicleWithStatus(registrationID, cfg)
+	return vehicle, err
+}
+
+// LookupVehicleWithStatus is LookupVehicle, also reporting whether the answer came from the cache.
+// The registration is normalised first, so "ab12 cde" and "AB12CDE" share a
+// cache entry and only letters and digits ever reach DVSA.
+func LookupVehicleWithStatus(registrationID string, cfg *config.Config) (*VehicleResponse, cache.Status, error) {
+	key := NormalisePlate(registrationID)
+	if key == "" {
+		return nil, "", fmt.Errorf("Tool B: registration %q: %w", registrationID, ErrInvalidRegistration)
+	}
+
+	cached, status := vehicleCache.Get(key)
+	switch status {
+	case cache.Hit:
+		return &cached, status, nil
+	case cache.NegativeHit:
+		return nil, status, fmt.Errorf("Tool B: registration %s (cached): %w", registrationID, ErrVehicleNotFound)
+	}
+
+	vehicle, err := fetchVehicle(key, cfg)
+	if err != nil {
+		if errors.Is(err, ErrVehicleNotFound) {
+			vehicleCache.SetNegative(key)
+		}
+		return nil, status, err
+	}
+
+	vehicleCache.Set(key, *vehicle)
+	return vehicle, status, nil
+}
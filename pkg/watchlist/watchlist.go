@@ -0,0 +1,361 @@
+// Package watchlist manages lists of plates of interest and checks ALPR
+// reads against them, notifying a webhook when a watched plate is seen.
+package watchlist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/instance"
+	"punkplod23/go-agent-ollama-slm/pkg/kvstore"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/notify"
+	"punkplod23/go-agent-ollama-slm/pkg/safefetch"
+	"punkplod23/go-agent-ollama-slm/pkg/tools"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrNotFound is returned for unknown watchlists or entries.
+var ErrNotFound = errors.New("not found")
+
+// Match kinds reported on a Hit.
+const (
+	MatchExact        = "exact"
+	MatchOCRCandidate = "ocr_candidate" // the read only matches after correcting an OCR confusion
+)
+
+// Watchlist is a named list of plates.
+type Watchlist struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	WebhookURL  string    `json:"webhook_url,omitempty"` // overrides WATCHLISTWEBHOOKURL for this list
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Entry is a plate on a watchlist.
+type Entry struct {
+	Plate     string     `json:"plate"`
+	Reason    string     `json:"reason"`
+	AddedAt   time.Time  `json:"added_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// Active reports whether the entry has not yet expired.
+func (e Entry) Active(now time.Time) bool {
+	return e.ExpiresAt == nil || now.Before(*e.ExpiresAt)
+}
+
+// Hit is raised when an ALPR read matches an active entry.
+type Hit struct {
+	ID            string    `json:"id"`
+	WatchlistID   string    `json:"watchlist_id"`
+	WatchlistName string    `json:"watchlist_name"`
+	Plate         string    `json:"plate"`
+	Reason        string    `json:"reason"`
+	ReadText      string    `json:"read_text"`
+	Confidence    float64   `json:"confidence"`
+	Match         string    `json:"match"`
+	DetectedAt    time.Time `json:"detected_at"`
+}
+
+// Store persists watchlists and their entries.
+type Store struct {
+	db *kvstore.Store
+	// mu serialises compound updates such as deleting a list with its entries.
+	mu sync.Mutex
+}
+
+const (
+	listPrefix  = "list/"
+	entryPrefix = "entry/"
+)
+
+func entryKey(listID, plate string) string { return entryPrefix + listID + "/" + plate }
+
+// Open opens the watchlist store at path; an empty path keeps it in memory.
+func Open(path string) (*Store, error) {
+	db, err := kvstore.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open watchlist store: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// CreateList adds a new watchlist.
+func (s *Store) CreateList(list Watchlist) (*Watchlist, error) {
+	list.Name = strings.TrimSpace(list.Name)
+	if list.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if list.WebhookURL != "" {
+		if _, err := safefetch.ValidateURL(list.WebhookURL); err != nil {
+			return nil, fmt.Errorf("invalid webhook_url: %w", err)
+		}
+	}
+	list.ID = uuid.New().String()
+	list.CreatedAt = time.Now().UTC()
+	if err := s.db.Put(listPrefix+list.ID, list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// GetList returns a watchlist by ID.
+func (s *Store) GetList(id string) (*Watchlist, error) {
+	var list Watchlist
+	found, err := s.db.Get(listPrefix+id, &list)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("watchlist %s: %w", id, ErrNotFound)
+	}
+	return &list, nil
+}
+
+// Lists returns every watchlist.
+func (s *Store) Lists() ([]Watchlist, error) {
+	lists := []Watchlist{}
+	var decodeErr error
+	s.db.Scan(listPrefix, func(_ string, v json.RawMessage) bool {
+		var list Watchlist
+		if decodeErr = json.Unmarshal(v, &list); decodeErr != nil {
+			return false
+		}
+		lists = append(lists, list)
+		return true
+	})
+	return lists, decodeErr
+}
+
+// DeleteList removes a watchlist and all of its entries.
+func (s *Store) DeleteList(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.GetList(id); err != nil {
+		return err
+	}
+	for _, key := range s.db.Keys(entryPrefix + id + "/") {
+		if err := s.db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return s.db.Delete(listPrefix + id)
+}
+
+// AddEntry adds or replaces a plate on a watchlist.
+func (s *Store) AddEntry(listID string, entry Entry) (*Entry, error) {
+	if _, err := s.GetList(listID); err != nil {
+		return nil, err
+	}
+	entry.Plate = tools.NormalisePlate(entry.Plate)
+	if entry.Plate == "" {
+		return nil, fmt.Errorf("plate is required")
+	}
+	if entry.AddedAt.IsZero() {
+		entry.AddedAt = time.Now().UTC()
+	}
+	if err := s.db.Put(entryKey(listID, entry.Plate), entry); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// RemoveEntry takes a plate off a watchlist.
+func (s *Store) RemoveEntry(listID, plate string) error {
+	key := entryKey(listID, tools.NormalisePlate(plate))
+	var existing Entry
+	found, err := s.db.Get(key, &existing)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("plate %s on watchlist %s: %w", plate, listID, ErrNotFound)
+	}
+	return s.db.Delete(key)
+}
+
+// Entries returns every entry on a watchlist, including expired ones.
+func (s *Store) Entries(listID string) ([]Entry, error) {
+	if _, err := s.GetList(listID); err != nil {
+		return nil, err
+	}
+	entries := []Entry{}
+	var decodeErr error
+	s.db.Scan(entryPrefix+listID+"/", func(_ string, v json.RawMessage) bool {
+		var e Entry
+		if decodeErr = json.Unmarshal(v, &e); decodeErr != nil {
+			return false
+		}
+		entries = append(entries, e)
+		return true
+	})
+	return entries, decodeErr
+}
+
+// ImportCSV adds entries from CSV rows of plate, reason and an optional
+// RFC 3339 or YYYY-MM-DD expiry. A header row starting with "plate" is skipped.
+func (s *Store) ImportCSV(listID string, r io.Reader) (int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return 0, fmt.Errorf("invalid watchlist CSV: %w", err)
+	}
+	if len(rows) > 0 && strings.EqualFold(strings.TrimSpace(rows[0][0]), "plate") {
+		rows = rows[1:]
+	}
+
+	var entries []Entry
+	for n, row := range rows {
+		entry := Entry{Plate: row[0]}
+		if len(row) > 1 {
+			entry.Reason = strings.TrimSpace(row[1])
+		}
+		if len(row) > 2 && strings.TrimSpace(row[2]) != "" {
+			expires, err := parseExpiry(row[2])
+			if err != nil {
+				return 0, fmt.Errorf("row %d: %w", n+1, err)
+			}
+			entry.ExpiresAt = &expires
+		}
+		if tools.NormalisePlate(entry.Plate) == "" {
+			return 0, fmt.Errorf("row %d: plate is required", n+1)
+		}
+		entries = append(entries, entry)
+	}
+
+	for i, entry := range entries {
+		if _, err := s.AddEntry(listID, entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+func parseExpiry(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		// A bare date means the entry is valid until the end of that day.
+		return t.Add(24 * time.Hour), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid expiry %q: use RFC 3339 or YYYY-MM-DD", v)
+}
+
+// Check matches ALPR reads against every active entry on every list. A read
+// also matches when one of its OCR-confusion candidates is watched.
+func (s *Store) Check(results []tools.ALPRResult, now time.Time) ([]Hit, error) {
+	lists, err := s.Lists()
+	if err != nil {
+		return nil, err
+	}
+
+	hits := []Hit{}
+	for _, result := range results {
+		read := tools.NormalisePlate(result.OCR.Text)
+		if read == "" {
+			continue
+		}
+		tries := []struct{ plate, match string }{{read, MatchExact}}
+		for _, c := range tools.PlateCandidates(read) {
+			tries = append(tries, struct{ plate, match string }{c.Registration, MatchOCRCandidate})
+		}
+
+		for _, list := range lists {
+			for _, try := range tries {
+				var entry Entry
+				found, err := s.db.Get(entryKey(list.ID, try.plate), &entry)
+				if err != nil {
+					return hits, err
+				}
+				if !found || !entry.Active(now) {
+					continue
+				}
+				hits = append(hits, Hit{
+					ID:            uuid.New().String(),
+					WatchlistID:   list.ID,
+					WatchlistName: list.Name,
+					Plate:         entry.Plate,
+					Reason:        entry.Reason,
+					ReadText:      result.OCR.Text,
+					Confidence:    result.OCR.Confidence,
+					Match:         try.match,
+					DetectedAt:    now.UTC(),
+				})
+				break // one hit per list per read
+			}
+		}
+	}
+	return hits, nil
+}
+
+// --- Process-wide store and notifications ---
+
+var (
+	defaultStore      = instance.New(func() (*Store, error) { return Open("") })
+	defaultWebhookURL string
+)
+
+// Configure opens the watchlist store selected by the configuration.
+func Configure(cfg *config.Config) error {
+	store, err := Open(cfg.WatchlistStorePath)
+	if err != nil {
+		return err
+	}
+	defaultStore.Set(store)
+	defaultWebhookURL = cfg.WatchlistWebhookURL
+	return nil
+}
+
+// Default returns the configured store, or an in-memory one if Configure has
+// not run.
+func Default() *Store {
+	return defaultStore.Get()
+}
+
+// CheckAndNotify checks ALPR reads against the watchlists and fires a
+// webhook event for each hit in the background. Hits are returned for
+// inclusion in API responses; lookup failures are logged, not returned, so a
+// watchlist problem never fails an ALPR request.
+func CheckAndNotify(results []tools.ALPRResult) []Hit {
+	store := Default()
+	hits, err := store.Check(results, time.Now())
+	if err != nil {
+		logging.Errorf("watchlist: check failed: %v", err)
+	}
+
+	for _, hit := range hits {
+		logging.Warnf("👀 WATCHLIST HIT: %s on %q (%s, %s match)", hit.Plate, hit.WatchlistName, hit.Reason, hit.Match)
+
+		// A list's own webhook was supplied through the API, so it is
+		// delivered as an untrusted callback.
+		url, post := defaultWebhookURL, notify.PostJSON
+		if list, err := store.GetList(hit.WatchlistID); err == nil && list.WebhookURL != "" {
+			url, post = list.WebhookURL, notify.PostJSONToCallback
+		}
+		if url == "" {
+			continue
+		}
+		hit, url, post := hit, url, post
+		notify.Go(func() {
+			event := map[string]interface{}{"event": "watchlist.hit", "hit": hit}
+			if err := post(url, hit.ID, event); err != nil {
+				logging.Errorf("watchlist: %v", err)
+			}
+		})
+	}
+	return hits
+}
@@ -0,0 +1,164 @@
+package webui
+
+import (
+	"errors"
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/config"
+	"sort"
+	"strings"
+)
+
+// ErrModelNotAllowed is returned when a chat asks for a model that is not
+// configured.
+var ErrModelNotAllowed = errors.New("model not allowed")
+
+// ModelAliases parses MODELALIASES into alias name -> model chain.
+func ModelAliases(cfg *config.Config) map[string][]string {
+	aliases := map[string][]string{}
+	for _, entry := range splitList(cfg.ModelAliases) {
+		name, models, ok := strings.Cut(entry, "=")
+		if !ok {
+			continue
+		}
+		var chain []string
+		for _, m := range strings.Split(models, "|") {
+			if m = strings.TrimSpace(m); m != "" {
+				chain = append(chain, m)
+			}
+		}
+		if name = strings.TrimSpace(name); name != "" && len(chain) > 0 {
+			aliases[name] = chain
+		}
+	}
+	return aliases
+}
+
+// AllowedModels returns every model a chat may use, sorted: the
+// OPENWEBUIMODELS allow-list plus OPENWEBUIMODELNAME, MODELFALLBACKS and
+// the models aliases point to.
+func AllowedModels(cfg *config.Config) []string {
+	set := map[string]bool{}
+	if cfg.OpenWebUIModelName != "" {
+		set[cfg.OpenWebUIModelName] = true
+	}
+	for _, m := range splitList(cfg.OpenWebUIModels) {
+		set[m] = true
+	}
+	for _, m := range ModelFallbacks(cfg) {
+		set[m] = true
+	}
+	for _, chain := range ModelAliases(cfg) {
+		for _, m := range chain {
+			set[m] = true
+		}
+	}
+	models := make([]string, 0, len(set))
+	for m := range set {
+		models = append(models, m)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// ModelFallbacks returns MODELFALLBACKS in order.
+func ModelFallbacks(cfg *config.Config) []string {
+	return splitList(cfg.ModelFallbacks)
+}
+
+// ModelChain returns the models to try, in order, for a requested model or
+// alias: the alias chain or the model itself, then MODELFALLBACKS, without
+// repeats. An empty request uses OPENWEBUIMODELNAME.
+func ModelChain(cfg *config.Config, requested string) ([]string, error) {
+	requested = strings.TrimSpace(requested)
+	if requested == "" {
+		requested = cfg.OpenWebUIModelName
+	}
+
+	chain, ok := ModelAliases(cfg)[requested]
+	if !ok {
+		allowed := false
+		for _, m := range AllowedModels(cfg) {
+			if m == requested {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%w: %q; choose one of %s", ErrModelNotAllowed, requested, strings.Join(ModelChoices(cfg), ", "))
+		}
+		chain = []string{requested}
+	}
+
+	seen := map[string]bool{}
+	var models []string
+	for _, m := range append(append([]string{}, chain...), ModelFallbacks(cfg)...) {
+		if !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	return models, nil
+}
+
+// ModelChoices lists the names a chat request may use: aliases, then models.
+func ModelChoices(cfg *config.Config) []string {
+	var names []string
+	for name := range ModelAliases(cfg) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return append(names, AllowedModels(cfg)...)
+}
+
+// UpstreamModel is one entry from Open WebUI's /api/models. Models served
+// by Ollama carry Ollama's details; Params holds per-model overrides set in
+// Open WebUI, such as num_ctx.
+type UpstreamModel struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	OwnedBy string `json:"owned_by"`
+	Ollama  *struct {
+		Size    int64 `json:"size"`
+		Details struct {
+			Family            string `json:"family"`
+			ParameterSize     string `json:"parameter_size"`
+			QuantizationLevel string `json:"quantization_level"`
+		} `json:"details"`
+	} `json:"ollama,omitempty"`
+	Info *struct {
+		BaseModelID string                 `json:"base_model_id"`
+		Params      map[string]interface{} `json:"params"`
+	} `json:"info,omitempty"`
+}
+
+// ContextLength returns the num_ctx override set in Open WebUI, or 0.
+func (m UpstreamModel) ContextLength() int {
+	if m.Info == nil {
+		return 0
+	}
+	if n, ok := m.Info.Params["num_ctx"].(float64); ok {
+		return int(n)
+	}
+	return 0
+}
+
+// ListModels returns the models Open WebUI offers.
+func ListModels(cfg *config.Config) ([]UpstreamModel, error) {
+	var response struct {
+		Data []UpstreamModel `json:"data"`
+	}
+	if err := callAPI("GET", "/api/models", nil, &response, cfg); err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	return response.Data, nil
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
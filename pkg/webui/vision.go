@@ -0,0 +1,69 @@
+package webui
+
+import (
+	"fmt"
+	"punkplod23/go-agent-ollama-slm/config"
+)
+
+// ContentPart is one part of a multimodal chat message.
+type ContentPart struct {
+	Type     string    `json:"type"` // "text" or "image_url"
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+type VisionMessage struct {
+	Role    string        `json:"role"`
+	Content []ContentPart `json:"content"`
+}
+
+type VisionRequest struct {
+	Model    string          `json:"model"`
+	Messages []VisionMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+}
+
+// ChatCompletionResponse is the OpenAI-compatible reply from /api/chat/completions.
+type ChatCompletionResponse struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// DescribeImage sends an image and a prompt to a vision-capable model through
+// Open WebUI's chat completions API and returns the model's reply. Unlike
+// CreateMainChat it does not create a chat; the answer comes back directly.
+// An empty model uses OPENWEBUIMODELNAME.
+func DescribeImage(cfg *config.Config, model, prompt, imageDataURI string) (string, error) {
+	if model == "" {
+		model = cfg.OpenWebUIModelName
+	}
+
+	requestPayload := VisionRequest{
+		Model: model,
+		Messages: []VisionMessage{{
+			Role: "user",
+			Content: []ContentPart{
+				{Type: "text", Text: prompt},
+				{Type: "image_url", ImageURL: &ImageURL{URL: imageDataURI}},
+			},
+		}},
+		Stream: false,
+	}
+
+	var response ChatCompletionResponse
+	if err := callAPI("POST", "/api/chat/completions", requestPayload, &response, cfg); err != nil {
+		return "", fmt.Errorf("failed to describe image: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("model %s returned no choices", model)
+	}
+	return response.Choices[0].Message.Content, nil
+}
@@ -2,6 +2,7 @@ package webui
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,6 +11,10 @@ import (
 	"os"
 	"path/filepath"
 	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/logging"
+	"punkplod23/go-agent-ollama-slm/pkg/resilience"
 	"strings"
 	"time"
 
@@ -23,11 +28,19 @@ const (
 	MaxPollingAttempts = 15
 )
 
-// --- GLOBAL VARIABLE ---
-var (
-	userMessage      Message
-	assistantMessage Message // Replaced in Step 2, but used globally
-)
+// RetryPolicy applies to every Open WebUI call. Only idempotent requests
+// (such as polling the chat state) are retried; chat creation and completion
+// POSTs are attempted once.
+var RetryPolicy = resilience.DefaultPolicy
+
+func init() {
+	resilience.BreakerFor("openwebui", RetryPolicy)
+}
+
+// getHTTPClient returns the client used for all Open WebUI calls.
+func getHTTPClient() *http.Client {
+	return resilience.NewClient("openwebui", cassette.Wrap("openwebui", egress.Transport()), RetryPolicy, 60*time.Second)
+}
 
 // --- STRUCTS: Open WebUI API Models ---
 
@@ -106,6 +119,11 @@ type CompletedRequest struct {
 // ----------------------------------------------------------------------
 
 func callAPI(method, path string, requestBody interface{}, responseTarget interface{}, cfg *config.Config) error {
+	return callAPIContext(context.Background(), method, path, requestBody, responseTarget, cfg)
+}
+
+// callAPIContext is callAPI bounded by ctx, e.g. a per-model timeout.
+func callAPIContext(ctx context.Context, method, path string, requestBody interface{}, responseTarget interface{}, cfg *config.Config) error {
 	var reqBody io.Reader
 	var reqData []byte
 
@@ -120,7 +138,7 @@ func callAPI(method, path string, requestBody interface{}, responseTarget interf
 	}
 
 	url := cfg.OpenWebUIHostURL + path
-	req, err := http.NewRequest(method, url, reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return err
 	}
@@ -128,23 +146,24 @@ func callAPI(method, path string, requestBody interface{}, responseTarget interf
 	req.Header.Set("Authorization", "Bearer "+cfg.OpenWebUIToken)
 	req.Header.Set("Content-Type", "application/json")
 
-	// DUMP REQUEST DETAILS
-	fmt.Println("\n==============================================")
-	fmt.Printf("➡️ DUMPING REQUEST: %s %s\n", method, cfg.OpenWebUIHostURL+path)
-	fmt.Println("----------------------------------------------")
-	fmt.Printf("Headers:\n")
-	for key, values := range req.Header {
-		fmt.Printf("  %s: %s\n", key, strings.Join(values, ", "))
-	}
-	if len(reqData) > 0 {
-		fmt.Printf("Body:\n%s\n", string(reqData))
-	} else {
-		fmt.Println("Body: (None)")
+	// DUMP REQUEST DETAILS (LOGLEVEL=debug only; the token is masked)
+	if logging.Enabled(logging.Debug) {
+		var dump strings.Builder
+		for key, values := range req.Header {
+			if key == "Authorization" {
+				values = []string{"Bearer ********"}
+			}
+			fmt.Fprintf(&dump, "  %s: %s\n", key, strings.Join(values, ", "))
+		}
+		body := "(None)"
+		if len(reqData) > 0 {
+			body = string(reqData)
+		}
+		logging.Debugf("➡️ DUMPING REQUEST: %s %s\nHeaders:\n%sBody:\n%s", method, url, dump.String(), body)
 	}
-	fmt.Println("==============================================")
 
 	// 2. EXECUTE REQUEST
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := getHTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("API request failed to %s: %w", url, err)
@@ -154,11 +173,7 @@ func callAPI(method, path string, requestBody interface{}, responseTarget interf
 	responseBody, _ := io.ReadAll(resp.Body)
 
 	// DUMP RESPONSE DETAILS
-	fmt.Printf("⬅️ DUMPING RESPONSE: %s\n", url)
-	fmt.Println("----------------------------------------------")
-	fmt.Printf("Status: %d\n", resp.StatusCode)
-	fmt.Printf("Response Body (Truncated):\n%s\n", string(responseBody))
-	fmt.Println("----------------------------------------------")
+	logging.Debugf("⬅️ DUMPING RESPONSE: %s\nStatus: %d\nResponse Body:\n%s", url, resp.StatusCode, string(responseBody))
 
 	// 3. CHECK STATUS AND DECODE
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -218,13 +233,12 @@ func uploadFileAPI(path string, filePath string, cfg *config.Config) (map[string
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	req.Header.Set("Accept", "application/json")
 
-	// DUMP REQUEST DETAILS (optional but good for debugging)
-	fmt.Printf("➡️ DUMPING FILE UPLOAD REQUEST: POST %s\n", url)
-	fmt.Printf("   File: %s\n", filePath)
+	// DUMP REQUEST DETAILS (LOGLEVEL=debug only)
 	// Body is not dumped because it's binary data
+	logging.Debugf("➡️ DUMPING FILE UPLOAD REQUEST: POST %s\n   File: %s", url, filePath)
 
 	// 7. Execute the request
-	client := &http.Client{Timeout: 60 * time.Second}
+	client := getHTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("API request failed: %w", err)
@@ -238,9 +252,7 @@ func uploadFileAPI(path string, filePath string, cfg *config.Config) (map[string
 	}
 
 	// DUMP RESPONSE
-	fmt.Printf("⬅️ DUMPING RESPONSE: %s\n", url)
-	fmt.Printf("   Status: %d\n", resp.StatusCode)
-	fmt.Printf("   Response Body:\n%s\n", string(responseBody))
+	logging.Debugf("⬅️ DUMPING RESPONSE: %s\n   Status: %d\n   Response Body:\n%s", url, resp.StatusCode, string(responseBody))
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return nil, fmt.Errorf("API call failed with status %d: %s", resp.StatusCode, string(responseBody))
@@ -275,7 +287,7 @@ func CreateFile(filename string, content string) error {
 		return fmt.Errorf("failed to write to file %s: %w", filename, err)
 	}
 
-	fmt.Printf("✅ Successfully created  file: %s\n", filename)
+	logging.Debugf("✅ Successfully created  file: %s", filename)
 	return nil
 }
 
@@ -286,24 +298,24 @@ func CreateFile(filename string, content string) error {
 // AddFileToKnowledgeCollection creates a markdown file and adds it to a knowledge collection.
 func AddFileToKnowledgeCollection(content, baseFilename, knowledgeID string, cfg *config.Config) (string, error) {
 	// 1. Create the local markdown file first
-	fmt.Printf("DEBUG: TempDirPath: %s\n", cfg.TempDirPath)
+	logging.Debugf("TempDirPath: %s", cfg.TempDirPath)
 
 	// Ensure the temporary directory exists
 	if err := os.MkdirAll(cfg.TempDirPath, os.ModePerm); err != nil {
 		return "", fmt.Errorf("failed to create temporary directory %s: %w", cfg.TempDirPath, err)
 	}
-	fmt.Printf("DEBUG: os.MkdirAll returned nil for %s\n", cfg.TempDirPath)
+	logging.Debugf("os.MkdirAll returned nil for %s", cfg.TempDirPath)
 
 	filename := filepath.Join(cfg.TempDirPath, baseFilename)
-	fmt.Printf("DEBUG: Full filename for creation: %s\n", filename)
+	logging.Debugf("Full filename for creation: %s", filename)
 	err := CreateFile(filename, content)
 	if err != nil {
 		return "", fmt.Errorf("failed to create markdown file: %w", err)
 	}
-	fmt.Printf("✅ Successfully created temporary file: %s\n", filename)
+	logging.Debugf("✅ Successfully created temporary file: %s", filename)
 
 	// 2. Upload the file to Open WebUI
-	fmt.Println("Uploading file to Open WebUI...")
+	logging.Debugf("Uploading file to Open WebUI...")
 	uploadResponse, err := uploadFileAPI("/api/v1/files/", filename, cfg)
 	if err != nil {
 		return "", fmt.Errorf("failed to upload file: %w", err)
@@ -313,10 +325,10 @@ func AddFileToKnowledgeCollection(content, baseFilename, knowledgeID string, cfg
 	if !ok || fileID == "" {
 		return "", fmt.Errorf("failed to extract file_id from upload response: %+v", uploadResponse)
 	}
-	fmt.Printf("✅ File uploaded successfully. File ID: %s\n", fileID)
+	logging.Debugf("✅ File uploaded successfully. File ID: %s", fileID)
 
 	// 3. Add the uploaded file to the knowledge collection
-	fmt.Printf("Adding file %s to knowledge collection %s...\n", fileID, knowledgeID)
+	logging.Debugf("Adding file %s to knowledge collection %s...", fileID, knowledgeID)
 
 	addFilePath := fmt.Sprintf("/api/v1/knowledge/%s/file/add", knowledgeID)
 	requestBody := map[string]string{"file_id": fileID}
@@ -326,7 +338,7 @@ func AddFileToKnowledgeCollection(content, baseFilename, knowledgeID string, cfg
 		return "", fmt.Errorf("failed to add file to knowledge collection: %w", err)
 	}
 
-	fmt.Printf("✅ Successfully added file to knowledge collection %s.\n", knowledgeID)
+	logging.Debugf("✅ Successfully added file to knowledge collection %s.", knowledgeID)
 
 	// 4. (Optional) Clean up the temporary file
 	// err = os.Remove(filename)
@@ -341,17 +353,23 @@ func AddFileToKnowledgeCollection(content, baseFilename, knowledgeID string, cfg
 // --- CHAT FLOW FUNCTIONS ---
 // ----------------------------------------------------------------------
 
-// 1. Create a new chat with the user message
-func createChat(userQuestion string, cfg *config.Config) (string, string, error) {
+// chatTools returns the Open WebUI tools enabled by OPENWEBUITOOLS.
+func chatTools(cfg *config.Config) []string {
+	return splitList(cfg.OpenWebUITools)
+}
+
+// 1. Create a new chat with the user message, which is returned for the
+// later steps of the same chat.
+func createChat(userQuestion, model string, cfg *config.Config) (string, Message, error) {
 	userMsgID := uuid.New().String()
 	timestamp := time.Now().UnixMilli()
 
-	userMessage = Message{ // Save to global for reuse in subsequent steps
+	userMessage := Message{
 		ID:        userMsgID,
 		Role:      "user",
 		Content:   userQuestion,
 		Timestamp: timestamp,
-		Models:    []string{cfg.OpenWebUIModelName},
+		Models:    []string{model},
 	}
 
 	requestPayload := struct {
@@ -359,9 +377,9 @@ func createChat(userQuestion string, cfg *config.Config) (string, string, error)
 	}{
 		Chat: Chat{
 			Title:    userQuestion,
-			Models:   []string{cfg.OpenWebUIModelName},
+			Models:   []string{model},
 			Messages: []Message{userMessage},
-			Tools:    []string{"DVSA Lookup"},
+			Tools:    chatTools(cfg),
 			History: History{
 				CurrentID: userMsgID,
 				Messages:  map[string]Message{userMsgID: userMessage},
@@ -373,20 +391,21 @@ func createChat(userQuestion string, cfg *config.Config) (string, string, error)
 
 	err := callAPI("POST", "/api/v1/chats/new", requestPayload, &rawResponse, cfg)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to create chat: %w", err)
+		return "", Message{}, fmt.Errorf("failed to create chat: %w", err)
 	}
 
 	chatID, ok := rawResponse["id"].(string)
 	if !ok || chatID == "" {
-		return "", "", fmt.Errorf("failed to extract top-level ChatID from API response")
+		return "", Message{}, fmt.Errorf("failed to extract top-level ChatID from API response")
 	}
 
-	fmt.Printf("✅ Step 1: Chat created. ID: %s\n", chatID)
-	return chatID, userMsgID, nil
+	logging.Debugf("✅ Step 1: Chat created. ID: %s", chatID)
+	return chatID, userMessage, nil
 }
 
-// 2 & 4. Centralized function to update the existing chat state
-func updateChat(chatID string, step int, userMsgID string, assistantMsgID string, cfg *config.Config, question string) error {
+// 2 & 4. Centralized function to update the existing chat state. userMessage
+// is a copy, so setting its model here does not affect other chats.
+func updateChat(chatID string, step int, userMessage Message, assistantMsgID string, cfg *config.Config, question, model string) error {
 
 	// 1. Re-initialize assistantMessage for the update payload
 	// This is done to ensure the timestamp is fresh for the update request
@@ -395,15 +414,15 @@ func updateChat(chatID string, step int, userMsgID string, assistantMsgID string
 		ID:        assistantMsgID,
 		Role:      "assistant",
 		Content:   "",
-		ParentID:  userMsgID,
+		ParentID:  userMessage.ID,
 		Timestamp: time.Now().UnixMilli(),
-		ModelName: cfg.OpenWebUIModelName,
+		ModelName: model,
 		ModelIdx:  0,
-		Models:    []string{cfg.OpenWebUIModelName},
+		Models:    []string{model},
 	}
 
-	// Update global assistantMessage with the fresh payload for the next step
-	assistantMessage = currentAssistantMessage
+	// A fallback retries the same chat with another model.
+	userMessage.Models = []string{model}
 
 	// Construct the payload
 	chatPayload := struct {
@@ -412,12 +431,12 @@ func updateChat(chatID string, step int, userMsgID string, assistantMsgID string
 		Chat: Chat{
 			ID:     chatID,
 			Title:  question,
-			Models: []string{cfg.OpenWebUIModelName},
+			Models: []string{model},
 			Messages: []Message{
 				userMessage,
 				currentAssistantMessage,
 			},
-			Tools: []string{"DVSA Lookup"},
+			Tools: chatTools(cfg),
 			History: History{
 				CurrentID: currentAssistantMessage.ID,
 				Messages: map[string]Message{
@@ -440,18 +459,18 @@ func updateChat(chatID string, step int, userMsgID string, assistantMsgID string
 		return fmt.Errorf("failed to %s: %w", description, err)
 	}
 
-	fmt.Printf("✅ Step %d: %s done.\n", step, description)
+	logging.Debugf("✅ Step %d: %s done.", step, description)
 	return nil
 }
 
 // 3. Trigger the completion (POST /api/chat/completions)
-func triggerCompletion(chatID, assistantMsgID string, cfg *config.Config, knowledgeID string, documentID string) error {
+func triggerCompletion(ctx context.Context, chatID string, userMessage Message, assistantMsgID string, cfg *config.Config, knowledgeID string, documentID string, model string) error {
 
 	requestPayload := CompletionRequest{
 		ChatID:    chatID,
 		MessageID: assistantMsgID,
 		Messages:  []Message{userMessage},
-		Model:     cfg.OpenWebUIModelName,
+		Model:     model,
 		Stream:    true,
 		BackgroundTasks: BackgroundTasks{
 			TitleGeneration:    true,
@@ -486,22 +505,22 @@ func triggerCompletion(chatID, assistantMsgID string, cfg *config.Config, knowle
 		})
 	}
 
-	err := callAPI("POST", "/api/chat/completions", requestPayload, nil, cfg)
+	err := callAPIContext(ctx, "POST", "/api/chat/completions", requestPayload, nil, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to trigger completion: %w", err)
 	}
 
-	fmt.Println("✅ Step 3: Completion triggered successfully.")
+	logging.Debugf("✅ Step 3: Completion triggered successfully.")
 	return nil
 }
 
 // 5. Mark the completion as done (POST /api/chat/completed)
-func markCompletion(chatID, assistantMsgID string, cfg *config.Config) error {
+func markCompletion(chatID, assistantMsgID string, cfg *config.Config, model string) error {
 
 	requestPayload := CompletedRequest{
 		ChatID:    chatID,
 		MessageID: assistantMsgID,
-		Model:     cfg.OpenWebUIModelName,
+		Model:     model,
 		SessionID: chatID,
 	}
 
@@ -510,7 +529,7 @@ func markCompletion(chatID, assistantMsgID string, cfg *config.Config) error {
 		return fmt.Errorf("failed to mark completion: %w", err)
 	}
 
-	fmt.Println("✅ Step 5: Completion marked as done.")
+	logging.Debugf("✅ Step 5: Completion marked as done.")
 	return nil
 }
 
@@ -551,41 +570,89 @@ func fetchFinalChatWithPolling(chatID, assistantMsgID string, cfg *config.Config
 	}
 
 	// SUCCESS: Content is found.
-	fmt.Printf("\nAssistant's Final Content:")
-	fmt.Println("---")
-	fmt.Println(latestMsg.Content)
-	fmt.Println("---")
+	logging.Debugf("Assistant's Final Content:\n---\n%s\n---", latestMsg.Content)
 
 	return latestMsg.Content, nil
 }
 
-func CreateMainChat(cfg *config.Config, prompt string, documentID string) (string, error) {
-	question := strings.TrimSpace(prompt)
-	fmt.Printf("Question: %s\n\n", question)
+// ChatResult reports how a chat was started: which model took it, and the
+// models that failed before it when a fallback was used.
+type ChatResult struct {
+	ChatID   string         `json:"chat_id"`
+	Model    string         `json:"model"`
+	Attempts []ModelAttempt `json:"failed_models,omitempty"`
+}
 
-	// --- EXECUTE FLOW ---
+// ModelAttempt is a model that errored or timed out.
+type ModelAttempt struct {
+	Model string `json:"model"`
+	Error string `json:"error"`
+}
 
-	chatID, userMsgID, err := createChat(question, cfg)
+// CreateMainChat starts a chat with OPENWEBUIMODELNAME, falling back along
+// MODELFALLBACKS, and returns the chat ID.
+func CreateMainChat(cfg *config.Config, prompt string, documentID string) (string, error) {
+	result, err := CreateChatWithModel(cfg, prompt, documentID, "")
 	if err != nil {
-		fmt.Println("Error:", err)
 		return "", err
 	}
+	return result.ChatID, nil
+}
 
-	// Generate assistant ID here, as it's used in all subsequent steps
-	assistantMsgID := uuid.New().String()
+// CreateChatWithModel starts a chat with the requested model or alias ("" for
+// OPENWEBUIMODELNAME). When a model errors or does not accept the completion
+// within MODELTIMEOUT, the chat is retried with the next model in its chain,
+// answering into a fresh assistant message so a late reply from the failed
+// model cannot land in the one being polled. When every model fails, the
+// result still lists the attempts.
+func CreateChatWithModel(cfg *config.Config, prompt, documentID, model string) (*ChatResult, error) {
+	question := strings.TrimSpace(prompt)
+	logging.Debugf("Question: %s", question)
 
-	// Step 2: Use the unified updateChat function to inject the empty message
-	err = updateChat(chatID, 2, userMsgID, assistantMsgID, cfg, question)
+	chain, err := ModelChain(cfg, model)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return "", err
+		return nil, err
 	}
 
-	err = triggerCompletion(chatID, assistantMsgID, cfg, "", documentID)
+	// --- EXECUTE FLOW ---
+
+	chatID, userMessage, err := createChat(question, chain[0], cfg)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return "", err
+		logging.Errorf("Error: %v", err)
+		return nil, err
+	}
+
+	result := &ChatResult{ChatID: chatID}
+	for _, m := range chain {
+		assistantMsgID := uuid.New().String()
+
+		// Step 2: Use the unified updateChat function to inject the empty message
+		err = updateChat(chatID, 2, userMessage, assistantMsgID, cfg, question, m)
+		if err == nil {
+			err = triggerWithTimeout(chatID, userMessage, assistantMsgID, cfg, documentID, m)
+		}
+		if err == nil {
+			result.Model = m
+			if len(result.Attempts) > 0 {
+				logging.Infof("🔀 Chat %s answered by fallback model %s", chatID, m)
+			}
+			return result, nil
+		}
+		logging.Warnf("⚠️ Model %s failed for chat %s: %v", m, chatID, err)
+		result.Attempts = append(result.Attempts, ModelAttempt{Model: m, Error: err.Error()})
 	}
+	return result, fmt.Errorf("every model failed (%d tried): %w", len(chain), err)
+}
 
-	return chatID, nil
+// triggerWithTimeout triggers the completion, giving up after MODELTIMEOUT.
+func triggerWithTimeout(chatID string, userMessage Message, assistantMsgID string, cfg *config.Config, documentID, model string) error {
+	ctx := context.Background()
+	if cfg.ModelTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.ModelTimeout)
+		defer cancel()
+	}
+	// The user message names the model being tried, as in the chat update.
+	userMessage.Models = []string{model}
+	return triggerCompletion(ctx, chatID, userMessage, assistantMsgID, cfg, "", documentID, model)
 }
@@ -0,0 +1,103 @@
+package webui_test
+
+import (
+	"encoding/json"
+	"flag"
+	"punkplod23/go-agent-ollama-slm/config"
+	"punkplod23/go-agent-ollama-slm/pkg/cassette"
+	"punkplod23/go-agent-ollama-slm/pkg/egress"
+	"punkplod23/go-agent-ollama-slm/pkg/webui"
+	"punkplod23/go-agent-ollama-slm/pkg/webui/webuitest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var record = flag.Bool("record", false, "record cassettes against webuitest instead of replaying them")
+
+// useCassettes replays the cassettes in dir and returns a configuration
+// pointing at an Open WebUI that is never dialled. With -record it starts a
+// fake Open WebUI, lets setup script it, and records the exchanges to dir.
+func useCassettes(t *testing.T, dir string, setup func(*webuitest.Server)) *config.Config {
+	t.Helper()
+	cfg := &config.Config{
+		OpenWebUIHostURL:   "http://openwebui.test",
+		OpenWebUIToken:     "test-token",
+		OpenWebUIModelName: "llama3:8b",
+		ModelTimeout:       5 * time.Second,
+	}
+	mode := cassette.ModeReplay
+	if *record {
+		srv := webuitest.NewServer(webuitest.Options{Token: cfg.OpenWebUIToken})
+		t.Cleanup(srv.Close)
+		if setup != nil {
+			setup(srv)
+		}
+		cfg.OpenWebUIHostURL, mode = srv.URL, cassette.ModeRecord
+		if err := egress.Configure(cfg); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { egress.Configure(&config.Config{}) })
+	}
+	if err := cassette.Use(mode, dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cassette.Use(cassette.ModeOff, "") })
+	return cfg
+}
+
+func TestCreateChatWithModelReplay(t *testing.T) {
+	cfg := useCassettes(t, "testdata/cassettes/chat", func(srv *webuitest.Server) {
+		srv.SetRules(webuitest.Rule{Contains: "AB12CDE", Reply: "AB12CDE is a silver 2018 Ford."})
+	})
+
+	result, err := webui.CreateChatWithModel(cfg, "  Tell me about AB12CDE  ", "", "")
+	if err != nil {
+		t.Fatalf("CreateChatWithModel: %v", err)
+	}
+	if result.ChatID == "" {
+		t.Error("no chat ID")
+	}
+	if result.Model != "llama3:8b" || len(result.Attempts) != 0 {
+		t.Errorf("result = %+v, want llama3:8b with no failed models", result)
+	}
+}
+
+func TestCreateChatWithModelFallbackReplay(t *testing.T) {
+	cfg := useCassettes(t, "testdata/cassettes/chat-fallback", func(srv *webuitest.Server) {
+		srv.FailNext("/api/chat/completions", 500, 1)
+	})
+	cfg.ModelFallbacks = "phi3:mini"
+
+	result, err := webui.CreateChatWithModel(cfg, "Tell me about AB12CDE", "", "")
+	if err != nil {
+		t.Fatalf("CreateChatWithModel: %v", err)
+	}
+	if result.Model != "phi3:mini" {
+		t.Errorf("answered by %q, want the fallback phi3:mini", result.Model)
+	}
+	if len(result.Attempts) != 1 || result.Attempts[0].Model != "llama3:8b" || !strings.Contains(result.Attempts[0].Error, "500") {
+		t.Errorf("failed models = %+v, want llama3:8b with status 500", result.Attempts)
+	}
+
+	// Each model must answer into its own assistant message, so a late
+	// reply from the failed one cannot be taken for the fallback's.
+	c, err := cassette.Load("testdata/cassettes/chat-fallback/openwebui.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messageIDs := map[string]bool{}
+	for _, i := range c.Interactions {
+		if i.Request.Path != "/api/chat/completions" {
+			continue
+		}
+		var completion webui.CompletionRequest
+		if err := json.Unmarshal([]byte(i.Request.Body), &completion); err != nil {
+			t.Fatal(err)
+		}
+		messageIDs[completion.MessageID] = true
+	}
+	if len(messageIDs) != 2 {
+		t.Errorf("completions used %d assistant message IDs, want one per model", len(messageIDs))
+	}
+}
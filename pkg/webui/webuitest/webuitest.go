@@ -0,0 +1,487 @@
+// Package webuitest is an in-memory fake of the parts of Open WebUI that
+// pkg/webui uses, so the service can be run and tested without an Open
+// WebUI and Ollama stack. Replies are scripted, and latency and failures
+// can be injected per path.
+//
+// Use NewServer in tests, or Fake as an http.Handler in cmd/fakewebui.
+package webuitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"punkplod23/go-agent-ollama-slm/pkg/webui"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Rule scripts a reply: the first rule whose Contains appears in the last
+// user message (case-insensitively) answers. An empty Contains matches
+// anything, so a catch-all rule goes last.
+type Rule struct {
+	Contains string `json:"contains"`
+	Reply    string `json:"reply"`
+	Model    string `json:"model,omitempty"` // reported model; empty echoes the requested one
+}
+
+// Failure makes requests to a path fail with Status. Count limits how many
+// requests fail (0 fails every one); Rate fails that share of requests at
+// random instead.
+type Failure struct {
+	Path   string  `json:"path"` // exact request path, or a prefix ending in "*"
+	Status int     `json:"status"`
+	Count  int     `json:"count,omitempty"`
+	Rate   float64 `json:"rate,omitempty"`
+}
+
+// Options configure the fake.
+type Options struct {
+	// Token, when set, must be sent as a bearer token.
+	Token string
+	// Rules script the assistant's replies; without a match the reply
+	// echoes the question.
+	Rules []Rule
+	// Latency delays every response.
+	Latency time.Duration
+	// CompletionDelay is how long after a completion is triggered the reply
+	// appears in the chat, so callers have to poll as they do for real.
+	CompletionDelay time.Duration
+	// ChunkDelay spaces out streamed chunks.
+	ChunkDelay time.Duration
+	Failures   []Failure
+	// Models are listed by /api/models as Ollama models; DefaultModels
+	// when empty.
+	Models []string
+}
+
+// DefaultModels are the models listed when Options.Models is empty.
+var DefaultModels = []string{"llama3:8b", "phi3:mini"}
+
+// Request is a request the fake received, for assertions.
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+}
+
+// Fake is the fake Open WebUI.
+type Fake struct {
+	mu       sync.Mutex
+	opts     Options
+	chats    map[string]*webui.Chat
+	files    map[string]string   // file id -> file name
+	contents map[string][]byte   // file id -> content
+	knowl    map[string][]string // knowledge id -> file ids
+	requests []Request
+	router   *mux.Router
+}
+
+// New returns a fake with the given options.
+func New(opts Options) *Fake {
+	f := &Fake{
+		opts:     opts,
+		chats:    map[string]*webui.Chat{},
+		files:    map[string]string{},
+		contents: map[string][]byte{},
+		knowl:    map[string][]string{},
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/api/v1/chats/new", f.createChat).Methods("POST")
+	r.HandleFunc("/api/v1/chats/{id}", f.updateChat).Methods("POST")
+	r.HandleFunc("/api/v1/chats/{id}", f.getChat).Methods("GET")
+	r.HandleFunc("/api/chat/completions", f.completions).Methods("POST")
+	r.HandleFunc("/api/chat/completed", f.completed).Methods("POST")
+	r.HandleFunc("/api/models", f.listModels).Methods("GET")
+	r.HandleFunc("/api/v1/files/", f.uploadFile).Methods("POST")
+	r.HandleFunc("/api/v1/knowledge/{id}/file/add", f.addKnowledgeFile).Methods("POST")
+	f.router = r
+	return f
+}
+
+// Server is a fake running on a local test server.
+type Server struct {
+	*Fake
+	*httptest.Server
+}
+
+// NewServer starts a fake on a local test server. Point OPENWEBUIHOSTURL
+// at its URL and call Close when done.
+func NewServer(opts Options) *Server {
+	f := New(opts)
+	return &Server{Fake: f, Server: httptest.NewServer(f)}
+}
+
+// SetRules replaces the scripted replies.
+func (f *Fake) SetRules(rules ...Rule) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opts.Rules = rules
+}
+
+// FailNext makes the next count requests to path fail with status; a count
+// of 0 fails every request.
+func (f *Fake) FailNext(path string, status, count int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opts.Failures = append(f.opts.Failures, Failure{Path: path, Status: status, Count: count})
+}
+
+// SetLatency changes the delay applied to every response.
+func (f *Fake) SetLatency(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.opts.Latency = d
+}
+
+// Requests returns the requests received so far.
+func (f *Fake) Requests() []Request {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Request(nil), f.requests...)
+}
+
+// Chat returns a stored chat.
+func (f *Fake) Chat(id string) (webui.Chat, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, ok := f.chats[id]
+	if !ok {
+		return webui.Chat{}, false
+	}
+	return *c, true
+}
+
+// KnowledgeFiles returns the file ids added to a knowledge collection.
+func (f *Fake) KnowledgeFiles(knowledgeID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.knowl[knowledgeID]...)
+}
+
+// FileContent returns an uploaded file's content.
+func (f *Fake) FileContent(id string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.contents[id]
+	return data, ok
+}
+
+func (f *Fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+
+	f.mu.Lock()
+	f.requests = append(f.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+	latency, token := f.opts.Latency, f.opts.Token
+	status := f.injectedFailure(r.URL.Path)
+	f.mu.Unlock()
+
+	if latency > 0 {
+		time.Sleep(latency)
+	}
+	if token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"detail": "Not authenticated"})
+		return
+	}
+	if status != 0 {
+		log.Printf("webuitest: injecting %d for %s %s", status, r.Method, r.URL.Path)
+		writeJSON(w, status, map[string]string{"detail": "injected failure"})
+		return
+	}
+	f.router.ServeHTTP(w, r)
+}
+
+// injectedFailure returns the status to fail path with, or 0. Callers hold f.mu.
+func (f *Fake) injectedFailure(path string) int {
+	for i := range f.opts.Failures {
+		fl := &f.opts.Failures[i]
+		if fl.Path != path && !(strings.HasSuffix(fl.Path, "*") && strings.HasPrefix(path, strings.TrimSuffix(fl.Path, "*"))) {
+			continue
+		}
+		switch {
+		case fl.Rate > 0:
+			if rand.Float64() < fl.Rate {
+				return fl.Status
+			}
+		case fl.Count == 0:
+			return fl.Status
+		case fl.Count > 0:
+			fl.Count--
+			if fl.Count == 0 {
+				fl.Count = -1 // spent
+			}
+			return fl.Status
+		}
+	}
+	return 0
+}
+
+// reply picks the scripted reply for a question. Callers hold f.mu.
+func (f *Fake) reply(question, model string) (string, string) {
+	q := strings.ToLower(question)
+	for _, rule := range f.opts.Rules {
+		if rule.Contains == "" || strings.Contains(q, strings.ToLower(rule.Contains)) {
+			if rule.Model != "" {
+				model = rule.Model
+			}
+			return rule.Reply, model
+		}
+	}
+	return "You asked: " + question, model
+}
+
+func (f *Fake) createChat(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Chat webui.Chat `json:"chat"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	chat := req.Chat
+	chat.ID = uuid.New().String()
+	f.mu.Lock()
+	f.chats[chat.ID] = &chat
+	f.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": chat.ID, "title": chat.Title, "chat": chat})
+}
+
+func (f *Fake) updateChat(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	var req struct {
+		Chat webui.Chat `json:"chat"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.chats[id]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "chat not found"})
+		return
+	}
+	chat := req.Chat
+	chat.ID = id
+	f.chats[id] = &chat
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "chat": chat})
+}
+
+// getChat answers with a one-element array, which is what pkg/webui polls for.
+func (f *Fake) getChat(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chat, ok := f.chats[id]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "chat not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, []webui.Chat{*chat})
+}
+
+// completionRequest covers both the chat flow's CompletionRequest and the
+// OpenAI-style vision request, whose content is a list of parts.
+type completionRequest struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"id"`
+	Model     string `json:"model"`
+	Stream    bool   `json:"stream"`
+	Messages  []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	} `json:"messages"`
+}
+
+// question returns the text of the last user message.
+func (c completionRequest) question() string {
+	for i := len(c.Messages) - 1; i >= 0; i-- {
+		m := c.Messages[i]
+		if m.Role != "user" {
+			continue
+		}
+		var text string
+		if json.Unmarshal(m.Content, &text) == nil {
+			return text
+		}
+		var parts []webui.ContentPart
+		if json.Unmarshal(m.Content, &parts) == nil {
+			var texts []string
+			for _, p := range parts {
+				if p.Type == "text" {
+					texts = append(texts, p.Text)
+				}
+			}
+			return strings.Join(texts, "\n")
+		}
+	}
+	return ""
+}
+
+func (f *Fake) completions(w http.ResponseWriter, r *http.Request) {
+	var req completionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	content, model := f.reply(req.question(), req.Model)
+	delay, chunkDelay := f.opts.CompletionDelay, f.opts.ChunkDelay
+	_, known := f.chats[req.ChatID]
+	f.mu.Unlock()
+
+	if req.ChatID != "" {
+		if !known {
+			writeJSON(w, http.StatusNotFound, map[string]string{"detail": "chat not found"})
+			return
+		}
+		// The real server writes the reply into the chat in the background.
+		go func() {
+			time.Sleep(delay)
+			f.storeReply(req.ChatID, req.MessageID, content, model)
+		}()
+	}
+
+	if !req.Stream {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id":      "chatcmpl-" + uuid.New().String(),
+			"object":  "chat.completion",
+			"model":   model,
+			"choices": []map[string]interface{}{{"index": 0, "message": map[string]string{"role": "assistant", "content": content}, "finish_reason": "stop"}},
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	for _, word := range strings.SplitAfter(content, " ") {
+		chunk, _ := json.Marshal(map[string]interface{}{
+			"object":  "chat.completion.chunk",
+			"model":   model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{"content": word}}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if chunkDelay > 0 {
+			time.Sleep(chunkDelay)
+		}
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// storeReply writes the assistant's reply into the chat history.
+func (f *Fake) storeReply(chatID, messageID, content, model string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	chat, ok := f.chats[chatID]
+	if !ok {
+		return
+	}
+	if chat.History.Messages == nil {
+		chat.History.Messages = map[string]webui.Message{}
+	}
+	msg := chat.History.Messages[messageID]
+	msg.ID, msg.Role, msg.Content, msg.ModelName = messageID, "assistant", content, model
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().UnixMilli()
+	}
+	chat.History.Messages[messageID] = msg
+	chat.History.CurrentID = messageID
+}
+
+func (f *Fake) completed(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]bool{"status": true})
+}
+
+// listModels answers like Open WebUI does for models served by Ollama. The
+// family is the name before the tag, and a tag such as "8b" gives the size.
+func (f *Fake) listModels(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	names := f.opts.Models
+	f.mu.Unlock()
+	if len(names) == 0 {
+		names = DefaultModels
+	}
+
+	data := []map[string]interface{}{}
+	for _, name := range names {
+		family, tag, _ := strings.Cut(name, ":")
+		size := ""
+		if t := strings.ToLower(tag); len(t) > 1 && strings.HasSuffix(t, "b") && strings.Trim(t[:len(t)-1], "0123456789.") == "" {
+			size = strings.ToUpper(t)
+		}
+		data = append(data, map[string]interface{}{
+			"id":       name,
+			"name":     name,
+			"object":   "model",
+			"owned_by": "ollama",
+			"ollama": map[string]interface{}{
+				"name":    name,
+				"model":   name,
+				"details": map[string]interface{}{"family": family, "parameter_size": size, "quantization_level": "Q4_0"},
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (f *Fake) uploadFile(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": "multipart field \"file\" is required"})
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	id := uuid.New().String()
+	f.mu.Lock()
+	f.files[id] = header.Filename
+	f.contents[id] = data
+	f.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": id, "filename": header.Filename, "meta": map[string]interface{}{"size": len(data)}})
+}
+
+func (f *Fake) addKnowledgeFile(w http.ResponseWriter, r *http.Request) {
+	knowledgeID := mux.Vars(r)["id"]
+	var req struct {
+		FileID string `json:"file_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"detail": err.Error()})
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.files[req.FileID]; !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"detail": "file not found"})
+		return
+	}
+	f.knowl[knowledgeID] = append(f.knowl[knowledgeID], req.FileID)
+	writeJSON(w, http.StatusOK, map[string]interface{}{"id": knowledgeID, "files": f.knowl[knowledgeID]})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -15,16 +15,13 @@ curl -X POST "$BASE_URL/chat" \
      -H "Content-Type: application/json" \
      -d '{"prompt": "What is the status of the system?"}'
 
-# 2. Test Process Base64 Image Endpoint
-echo -e "\n\n[2] Testing Process Base64 Image Endpoint..."
-# 1x1 Pixel PNG Base64 - Use temp file to avoid "Argument list too long" error
+# 2. Test Process Image Endpoint
+echo -e "\n\n[2] Testing Process Image Endpoint..."
+# 1x1 Pixel PNG, streamed as raw bytes so no base64 JSON payload is needed
 IMAGE_DATA="iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="
-TEMP_PAYLOAD=$(mktemp)
-echo "{\"image_base64\": \"$IMAGE_DATA\"}" > "$TEMP_PAYLOAD"
-curl -X POST "$BASE_URL/process-base64-image" \
-     -H "Content-Type: application/json" \
-     -d @"$TEMP_PAYLOAD"
-rm -f "$TEMP_PAYLOAD"
+echo "$IMAGE_DATA" | base64 -d | curl -X POST "$BASE_URL/process-image" \
+     -H "Content-Type: image/png" \
+     --data-binary @-
 
 # 3. Test File Upload Endpoint
 echo -e "\n\n[3] Testing File Upload Endpoint..."